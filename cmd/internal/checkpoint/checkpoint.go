@@ -0,0 +1,215 @@
+// Package checkpoint keeps track of how far files were read,
+// so that reading them can be resumed where it stopped.
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrDuplicateID is returned when tracking a message whose
+// ID is shared by a message that was not acknowledged yet.
+var ErrDuplicateID = errors.New("message id is already pending")
+
+// entry is the checkpoint of a single file.
+type entry struct {
+	// Path is the path the file was last read at.
+	//
+	// It is only informational, since files are
+	// identified by their FileID.
+	Path string `json:"path"`
+
+	// Offset is the offset up to which all messages
+	// read from the file were acknowledged.
+	Offset int64 `json:"offset"`
+}
+
+// mark is a message read from a file that is
+// waiting to be acknowledged.
+type mark struct {
+	file  string
+	path  string
+	end   int64
+	acked bool
+}
+
+// Store holds the offsets up to which messages read from
+// files were acknowledged, keyed by the FileID of the file.
+//
+// Messages are tracked as they are read and can be
+// acknowledged in any order, but the offset of a file only
+// advances past messages that were all acknowledged. This
+// way, no message is skipped when reading is resumed from
+// the offset, although messages may be read again.
+//
+// Offsets are persisted to a file by Save.
+//
+// It is safe for concurrent use.
+type Store struct {
+	path string
+
+	// entries are the checkpoints by FileID.
+	entries map[string]entry
+
+	// dirty is set if entries changed since
+	// they were last saved.
+	dirty bool
+
+	// files holds the messages of every file that are yet
+	// to be acknowledged, in the order they were read.
+	files map[string][]*mark
+
+	// ids holds the messages that are yet to be
+	// acknowledged by their message ID.
+	ids map[string]*mark
+
+	m sync.Mutex
+}
+
+// Open opens the checkpoint file at path.
+//
+// The file is created by Save if it does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: make(map[string]entry),
+		files:   make(map[string][]*mark),
+		ids:     make(map[string]*mark),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoints: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("decode checkpoints: %w", err)
+	}
+
+	return s, nil
+}
+
+// Offset returns the offset from which the file with
+// the given FileID is to be read.
+//
+// It is zero for files that were not read before.
+func (s *Store) Offset(file string) int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.entries[file].Offset
+}
+
+// Track records that the message with the given ID was
+// read from the file with the given FileID, ending at
+// offset end.
+//
+// Messages of a file must be tracked in the order they
+// were read.
+//
+// If a message that was not acknowledged yet has the same
+// ID, ErrDuplicateID is returned. The message is tracked
+// as one that is never acknowledged, so that the offset
+// does not advance past it.
+func (s *Store) Track(id, file, path string, end int64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	mk := &mark{file: file, path: path, end: end}
+	s.files[file] = append(s.files[file], mk)
+
+	if _, ok := s.ids[id]; ok {
+		return ErrDuplicateID
+	}
+	s.ids[id] = mk
+
+	return nil
+}
+
+// Ack acknowledges the message with the given ID.
+//
+// IDs of messages that were not tracked, or were
+// acknowledged already, are ignored.
+func (s *Store) Ack(id string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	mk, ok := s.ids[id]
+	if !ok {
+		return
+	}
+	delete(s.ids, id)
+	mk.acked = true
+
+	// Advance past the messages at the start
+	// that were all acknowledged.
+	pending := s.files[mk.file]
+	i := 0
+	for i < len(pending) && pending[i].acked {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+
+	last := pending[i-1]
+	s.entries[mk.file] = entry{Path: last.path, Offset: last.end}
+	s.dirty = true
+
+	if i == len(pending) {
+		delete(s.files, mk.file)
+	} else {
+		s.files[mk.file] = pending[i:]
+	}
+}
+
+// Save writes the offsets to the checkpoint file if
+// they changed since they were last saved.
+//
+// The file is replaced atomically, so that it is never
+// left partially written.
+func (s *Store) Save() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode checkpoints: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create checkpoints: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync checkpoints: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace checkpoints: %w", err)
+	}
+	s.dirty = false
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package checkpoint_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/checkpoint"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	s, err := checkpoint.Open(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), s.Offset("f"))
+
+	assert.Nil(t, s.Track("1", "f", "a.log", 2))
+	assert.Nil(t, s.Track("2", "f", "a.log", 4))
+	assert.Nil(t, s.Track("3", "f", "a.log", 6))
+	assert.Nil(t, s.Track("4", "g", "b.log", 3))
+
+	// Not saved until a prefix of the
+	// messages is acknowledged.
+	s.Ack("2")
+	assert.Equal(t, int64(0), s.Offset("f"))
+	assert.Nil(t, s.Save())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	s.Ack("1")
+	assert.Equal(t, int64(4), s.Offset("f"))
+	s.Ack("unknown")
+	s.Ack("2")
+	s.Ack("3")
+	s.Ack("4")
+	assert.Equal(t, int64(6), s.Offset("f"))
+	assert.Equal(t, int64(3), s.Offset("g"))
+	assert.Nil(t, s.Save())
+
+	s, err = checkpoint.Open(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), s.Offset("f"))
+	assert.Equal(t, int64(3), s.Offset("g"))
+}
+
+func TestStore_DuplicateID(t *testing.T) {
+	t.Parallel()
+
+	s, err := checkpoint.Open(filepath.Join(t.TempDir(), "checkpoints.json"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Track("1", "f", "a.log", 2))
+	assert.ErrorIs(t, s.Track("1", "f", "a.log", 4), checkpoint.ErrDuplicateID)
+	assert.Nil(t, s.Track("2", "f", "a.log", 6))
+
+	// The message that is acknowledged is the one tracked
+	// under the ID, and the duplicate holds the offset.
+	s.Ack("1")
+	s.Ack("1")
+	s.Ack("2")
+	assert.Equal(t, int64(2), s.Offset("f"))
+
+	// The ID can be tracked again once acknowledged.
+	assert.Nil(t, s.Track("1", "g", "b.log", 3))
+	s.Ack("1")
+	assert.Equal(t, int64(3), s.Offset("g"))
+}
+
+func TestFileID(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	assert.Nil(t, os.WriteFile(path, nil, 0o644))
+
+	id := func(path string) string {
+		f, err := os.Open(path)
+		assert.Nil(t, err)
+		defer f.Close()
+
+		id, err := checkpoint.FileID(f)
+		assert.Nil(t, err)
+		return id
+	}
+
+	before := id(path)
+	assert.NotEmpty(t, before)
+
+	// Renaming keeps the identity, but a new
+	// file at the same path has another.
+	assert.Nil(t, os.Rename(path, path+".1"))
+	assert.Equal(t, before, id(path+".1"))
+
+	assert.Nil(t, os.WriteFile(path, nil, 0o644))
+	assert.NotEqual(t, before, id(path))
+}
@@ -0,0 +1,23 @@
+//go:build !(aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris || windows)
+
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileID returns a key that identifies the file.
+//
+// There is no portable file identity on this platform, so
+// it is the absolute path of the file, which means that
+// renamed files are not recognised.
+func FileID(f *os.File) (string, error) {
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("path of %s: %w", f.Name(), err)
+	}
+
+	return path, nil
+}
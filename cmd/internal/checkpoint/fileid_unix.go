@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileID returns a key that identifies the file across
+// renames, which is its device and inode number.
+func FileID(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("stat %s: no inode", f.Name())
+	}
+
+	return fmt.Sprintf("%d:%d", uint64(st.Dev), uint64(st.Ino)), nil
+}
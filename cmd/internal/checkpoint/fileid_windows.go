@@ -0,0 +1,20 @@
+//go:build windows
+
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileID returns a key that identifies the file across
+// renames, which is its volume serial number and file index.
+func FileID(f *os.File) (string, error) {
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &d); err != nil {
+		return "", fmt.Errorf("file information of %s: %w", f.Name(), err)
+	}
+
+	return fmt.Sprintf("%d:%d:%d", d.VolumeSerialNumber, d.FileIndexHigh, d.FileIndexLow), nil
+}
@@ -2,32 +2,62 @@ package cli
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
+	"github.com/vivangkumar/notify/cmd/internal/checkpoint"
+	"github.com/vivangkumar/notify/cmd/internal/input"
+	"github.com/vivangkumar/notify/cmd/internal/listen"
+	"github.com/vivangkumar/notify/cmd/internal/spool"
+	"github.com/vivangkumar/notify/cmd/internal/spooldir"
+	"github.com/vivangkumar/notify/cmd/internal/tail"
 	"github.com/vivangkumar/notify/cmd/internal/timedbuffer"
 	"github.com/vivangkumar/notify/pkg/notification"
 )
 
 const (
-	urlFlag            = "url"
-	intervalFlag       = "interval"
-	verboseFlag        = "verbose"
-	maxBufferSizeFlag  = "max-buffer-size"
-	maxRpsFlag         = "max-rps"
-	maxConcurrencyFlag = "max-concurrency"
+	urlFlag             = "url"
+	intervalFlag        = "interval"
+	verboseFlag         = "verbose"
+	maxBufferSizeFlag   = "max-buffer-size"
+	maxRpsFlag          = "max-rps"
+	maxConcurrencyFlag  = "max-concurrency"
+	shutdownTimeoutFlag = "shutdown-timeout"
+	undeliveredFileFlag = "undelivered-file"
+	onFullFlag          = "on-full"
+	spoolFileFlag       = "spool-file"
+	inputFormatFlag     = "input-format"
+	delimiterFlag       = "delimiter"
+	idFieldFlag         = "id-field"
+	keyFieldFlag        = "key-field"
+	priorityFieldFlag   = "priority-field"
+	maxMessageSizeFlag  = "max-message-size"
+	onOversizeFlag      = "on-oversize"
+	inputFlag           = "input"
+	followFlag          = "follow"
+	checkpointFileFlag  = "checkpoint-file"
+	spoolDirFlag        = "spool-dir"
+	listenFlag          = "listen"
 )
 
+// defaultMaxMessageSize is the default max size
+// of a message read from the input.
+const defaultMaxMessageSize = 1 << 20
+
+// exitCodeUndelivered is the exit code when some
+// messages could not be delivered.
+const exitCodeUndelivered = 2
+
 // New creates a new command line interface that allows
-// sending notifications from stdin.
+// sending notifications from stdin or files, or relaying
+// them from an HTTP API.
 func New() *cli.App {
 	return &cli.App{
 		Name:  "notifier",
-		Usage: "sends notifications from stdin",
+		Usage: "sends notifications from stdin or files",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:     urlFlag,
@@ -65,6 +95,81 @@ func New() *cli.App {
 				Value:   100,
 				Usage:   "max concurrency of the notifier client",
 			},
+			&cli.DurationFlag{
+				Name:  shutdownTimeoutFlag,
+				Value: 10 * time.Second,
+				Usage: "time given to deliver pending messages when shutting down",
+			},
+			&cli.StringFlag{
+				Name:  undeliveredFileFlag,
+				Usage: "file that undelivered messages are appended to (logged if not set)",
+			},
+			&cli.StringFlag{
+				Name:  onFullFlag,
+				Value: string(onFullDrop),
+				Usage: "what to do with messages when the buffer is full: drop, block or spill",
+			},
+			&cli.StringFlag{
+				Name:  spoolFileFlag,
+				Value: "notifier.spool",
+				Usage: "file that messages are spilled to with --on-full=spill",
+			},
+			&cli.StringFlag{
+				Name:  inputFormatFlag,
+				Value: string(input.FormatLine),
+				Usage: "format of the input: line, ndjson, csv, nul, delimited or length-prefixed",
+			},
+			&cli.StringFlag{
+				Name:  delimiterFlag,
+				Usage: "message delimiter with the delimited format, or field delimiter with csv",
+			},
+			&cli.StringFlag{
+				Name:  idFieldFlag,
+				Usage: "field holding the message id with the ndjson and csv formats",
+			},
+			&cli.StringFlag{
+				Name:  keyFieldFlag,
+				Usage: "field holding the message key, sent in the X-Message-Key header",
+			},
+			&cli.StringFlag{
+				Name:  priorityFieldFlag,
+				Usage: "field holding the integer message priority, higher is sent first",
+			},
+			&cli.IntFlag{
+				Name:  maxMessageSizeFlag,
+				Value: defaultMaxMessageSize,
+				Usage: "max size of a message in bytes",
+			},
+			&cli.StringFlag{
+				Name:  onOversizeFlag,
+				Value: string(input.OversizeReject),
+				Usage: "what to do with messages over --max-message-size: reject, truncate or chunk",
+			},
+			&cli.StringSliceFlag{
+				Name:    inputFlag,
+				Aliases: []string{"in"},
+				Usage:   "files or glob patterns to read instead of stdin, can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:    followFlag,
+				Aliases: []string{"f"},
+				Usage:   "follow the --input files as they are written to, including rotated and new files",
+			},
+			&cli.StringFlag{
+				Name:  checkpointFileFlag,
+				Usage: "file that records how far the --input files were delivered, to resume from on restart",
+			},
+			&cli.StringFlag{
+				Name:  spoolDirFlag,
+				Usage: "directory to send the files dropped into, which are then moved to done/ or failed/",
+			},
+			&cli.StringSliceFlag{
+				Name:  listenFlag,
+				Usage: "addresses to receive messages on, such as tcp://:9000, udp://:9000 or unix:///path, can be repeated",
+			},
+		},
+		Commands: []*cli.Command{
+			serveCommand(),
 		},
 		Action: run,
 	}
@@ -72,40 +177,233 @@ func New() *cli.App {
 
 // run is the main entry point to the program.
 func run(ctx *cli.Context) error {
-	url := ctx.String(urlFlag)
 	interval := ctx.Duration(intervalFlag)
-	verbose := ctx.Bool(verboseFlag)
 	maxBufferSize := ctx.Int(maxBufferSizeFlag)
-	maxRps := ctx.Uint64(maxRpsFlag)
 	maxConcurrency := ctx.Int(maxConcurrencyFlag)
+	shutdownTimeout := ctx.Duration(shutdownTimeoutFlag)
+	undeliveredFile := ctx.String(undeliveredFileFlag)
+	spoolFile := ctx.String(spoolFileFlag)
+	inputs := ctx.StringSlice(inputFlag)
+	follow := ctx.Bool(followFlag)
+	checkpointFile := ctx.String(checkpointFileFlag)
+	spoolDir := ctx.String(spoolDirFlag)
+	listenAddrs := ctx.StringSlice(listenFlag)
+
+	onFull, err := parseOnFullMode(ctx.String(onFullFlag))
+	if err != nil {
+		return err
+	}
+
+	inputOpts, err := inputOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(listenAddrs) > 0 && (len(inputs) > 0 || spoolDir != "") {
+		return fmt.Errorf("--%s cannot be combined with --%s or --%s", listenFlag, inputFlag, spoolDirFlag)
+	}
+	if spoolDir != "" {
+		if len(inputs) > 0 {
+			return fmt.Errorf("--%s cannot be combined with --%s", spoolDirFlag, inputFlag)
+		}
+		// Spilled messages have no outcome yet, so
+		// their files could not be moved.
+		if onFull == onFullSpill {
+			return fmt.Errorf("--%s=%s is not supported with --%s", onFullFlag, onFullSpill, spoolDirFlag)
+		}
+	}
+	if follow && len(inputs) == 0 {
+		return fmt.Errorf("--%s requires --%s", followFlag, inputFlag)
+	}
+	if checkpointFile != "" {
+		if len(inputs) == 0 {
+			return fmt.Errorf("--%s requires --%s", checkpointFileFlag, inputFlag)
+		}
+		// Rows cannot be located in CSV files, which
+		// must be read from the header anyway.
+		if inputOpts.Format == input.FormatCSV {
+			return fmt.Errorf("--%s is not supported with the csv format", checkpointFileFlag)
+		}
+	}
+	if !follow && len(inputs) > 0 {
+		// Followed files may not exist yet, but
+		// files that are read once must.
+		inputs, err = tail.Glob(inputs)
+		if err != nil {
+			return err
+		}
+	}
+
+	client, logger := newClient(ctx)
+	buffer := timedbuffer.NewOf[input.Record](interval, maxBufferSize)
+
+	cfg := notifierConfig{
+		input:           inputOpts,
+		stdin:           os.Stdin,
+		inputs:          inputs,
+		follow:          follow,
+		shutdownTimeout: shutdownTimeout,
+		// Room for every message the client holds, so that
+		// none are missed when they are discarded at once.
+		eventBufferSize: maxBufferSize + maxConcurrency,
+		onFull:          onFull,
+	}
+	if undeliveredFile != "" {
+		f, err := openUndelivered(undeliveredFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		cfg.undelivered = f
+	}
+
+	if onFull == onFullSpill {
+		s, err := spool.Open[input.Record](spoolFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := s.Close(); err != nil {
+				logger.Printf("close spool: %s\n", err.Error())
+			}
+		}()
+
+		cfg.spool = s
+	}
+
+	if spoolDir != "" {
+		cfg.spoolDir, err = spooldir.Open(spoolDir)
+		if err != nil {
+			return err
+		}
+	}
 
+	// Listen right away, so that addresses
+	// that are taken are reported.
+	for _, addr := range listenAddrs {
+		l, err := listen.Listen(addr)
+		if err != nil {
+			for _, l := range cfg.listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+
+		cfg.listeners = append(cfg.listeners, l)
+	}
+
+	if checkpointFile != "" {
+		cfg.checkpoints, err = checkpoint.Open(checkpointFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	notifier := newNotifier(client, buffer, cfg, logger)
+	notifier.start(ctx.Context)
+
+	return finish(notifier)
+}
+
+// newClient creates the notification client configured
+// with the global flags, along with the logger of the
+// notifier, which only logs warnings and errors unless
+// verbose.
+func newClient(ctx *cli.Context, opts ...notification.Opt) (*notification.Client, *log.Logger) {
 	logger := log.New()
 	logger.SetFormatter(&log.TextFormatter{})
-	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(log.WarnLevel)
 
 	clientOpts := []notification.Opt{
-		notification.WithMaxBufferSize(maxBufferSize),
-		notification.WithMaxRpsAndRefill(maxRps, 1),
-		notification.WithMaxConcurrency(maxConcurrency),
+		notification.WithMaxBufferSize(ctx.Int(maxBufferSizeFlag)),
+		notification.WithMaxRpsAndRefill(ctx.Uint64(maxRpsFlag), 1),
+		notification.WithMaxConcurrency(ctx.Int(maxConcurrencyFlag)),
 	}
-	if verbose {
+	if ctx.Bool(verboseFlag) {
 		clientOpts = append(
 			clientOpts,
 			notification.WithLoggingEnabled(log.InfoLevel),
 		)
-		logger.SetOutput(os.Stderr)
+		logger.SetLevel(log.InfoLevel)
 	}
 
-	client := notification.NewClient(url, clientOpts...)
-	buffer := timedbuffer.New(interval, maxBufferSize)
+	client := notification.NewClient(ctx.String(urlFlag), append(clientOpts, opts...)...)
 
-	notifier := newNotifier(client, buffer, logger)
-	notifier.start(ctx)
+	return client, logger
+}
 
-	err := notifier.stop()
+// openUndelivered opens the file that undelivered
+// messages are appended to.
+func openUndelivered(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
+		return nil, fmt.Errorf("open undelivered file: %w", err)
+	}
+
+	return f, nil
+}
+
+// finish stops the notifier and returns the error to
+// exit with, which has exitCodeUndelivered as its code
+// if some messages were not delivered.
+func finish(notifier *notifier) error {
+	if err := notifier.stop(); err != nil {
 		return fmt.Errorf("notifier: %w", err)
 	}
 
+	if err := notifier.readError(); err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+
+	return exitUndelivered(notifier.undelivered())
+}
+
+// exitUndelivered returns the error to exit with if n
+// messages were not delivered, which has
+// exitCodeUndelivered as its code.
+func exitUndelivered(n uint64) error {
+	if n > 0 {
+		return cli.Exit(
+			fmt.Sprintf("%d messages were not delivered", n),
+			exitCodeUndelivered,
+		)
+	}
+
 	return nil
 }
+
+// inputOptions returns the options to read the
+// input with, configured with the input flags.
+func inputOptions(ctx *cli.Context) (input.Options, error) {
+	format, err := input.ParseFormat(ctx.String(inputFormatFlag))
+	if err != nil {
+		return input.Options{}, err
+	}
+
+	oversize, err := input.ParseOversizeMode(ctx.String(onOversizeFlag))
+	if err != nil {
+		return input.Options{}, err
+	}
+
+	var delim rune
+	if d := []rune(ctx.String(delimiterFlag)); len(d) > 1 {
+		return input.Options{}, fmt.Errorf("delimiter must be a single character, got %q", string(d))
+	} else if len(d) == 1 {
+		delim = d[0]
+	}
+
+	opts := input.Options{
+		Format: format,
+		Fields: input.Fields{
+			ID:       ctx.String(idFieldFlag),
+			Key:      ctx.String(keyFieldFlag),
+			Priority: ctx.String(priorityFieldFlag),
+		},
+		Delimiter: delim,
+		MaxSize:   ctx.Int(maxMessageSizeFlag),
+		Oversize:  oversize,
+	}
+
+	return opts, opts.Validate()
+}
@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vivangkumar/notify/cmd/internal/checkpoint"
+	"github.com/vivangkumar/notify/cmd/internal/input"
+	"github.com/vivangkumar/notify/cmd/internal/spooldir"
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+// keyHeader is the header the key of a message
+// is sent in.
+const keyHeader = "X-Message-Key"
+
+// checkpointInterval is the interval at which
+// checkpoints are saved.
+const checkpointInterval = time.Second
+
+// deliveryConfig configures how the messages handed to
+// the client are settled, and where the ones that were
+// not delivered are recorded.
+type deliveryConfig struct {
+	// eventBufferSize is the number of failures that
+	// are buffered until they are recorded.
+	eventBufferSize int
+
+	// undelivered receives messages that were not
+	// delivered, one per line.
+	//
+	// They are logged if it is nil.
+	undelivered io.Writer
+
+	// checkpoints are advanced once the messages
+	// read from input files were delivered, if set.
+	checkpoints *checkpoint.Store
+
+	// spoolDir is told about the messages read from
+	// its files once they were handled, if set.
+	spoolDir *spooldir.Dir
+}
+
+// delivery hands messages to the client and keeps
+// track of what happens to them.
+//
+// Messages that the client fails to deliver are recorded,
+// and messages are settled once they were handled, so that
+// checkpoints advance and spooled files are moved.
+type delivery struct {
+	// rejected counts messages that were never handed
+	// to the client.
+	//
+	// It is accessed atomically and kept first to
+	// ensure 64-bit alignment.
+	rejected uint64
+
+	client notificationClient
+	cfg    deliveryConfig
+
+	// sub receives the events of the client.
+	sub *notification.Subscription
+
+	// done is closed when the delivery is stopped.
+	done chan struct{}
+
+	// m guards writes to cfg.undelivered.
+	m sync.Mutex
+
+	// keep track of our go routines
+	wg sync.WaitGroup
+
+	logger *log.Logger
+}
+
+func newDelivery(client notificationClient, cfg deliveryConfig, logger *log.Logger) *delivery {
+	return &delivery{
+		client: client,
+		cfg:    cfg,
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+}
+
+// start subscribes to the events of the client and
+// starts it, along with the go routines that record
+// failures and save checkpoints.
+func (d *delivery) start() {
+	types := []notification.EventType{
+		notification.EventFailed,
+		notification.EventDropped,
+		notification.EventExpired,
+	}
+	if d.cfg.checkpoints != nil || d.cfg.spoolDir != nil {
+		types = append(types, notification.EventSent)
+	}
+
+	// Subscribe before starting the client so
+	// that no failures are missed.
+	d.sub = d.client.Subscribe(
+		notification.WithEventBufferSize(d.cfg.eventBufferSize),
+		notification.WithEventTypes(types...),
+	)
+
+	d.wg.Add(1)
+	go d.events()
+
+	if d.cfg.checkpoints != nil {
+		d.wg.Add(1)
+		go d.saveCheckpoints()
+	}
+
+	d.client.Start()
+}
+
+// events records messages that the client failed to send,
+// and settles messages once they were handled.
+//
+// It exits once the subscription is closed when
+// the client is stopped.
+func (d *delivery) events() {
+	defer d.wg.Done()
+
+	for ev := range d.sub.Events() {
+		if ev.Type != notification.EventSent {
+			d.logger.Printf("client error: %s: %s\n", ev.Type, ev.Err.Error())
+			d.record(ev.Message)
+		}
+
+		d.settle(ev.MessageID, ev.Type == notification.EventSent)
+	}
+}
+
+// settle records that the message with the ID was handled,
+// either because it was delivered or because it was recorded
+// as undelivered.
+//
+// Spilled messages count as delivered, as they are
+// kept until they are.
+//
+// Checkpoints only advance past delivered messages, so that
+// the ones that were not are read again by the next run.
+func (d *delivery) settle(id string, delivered bool) {
+	if d.cfg.checkpoints != nil && delivered {
+		d.cfg.checkpoints.Ack(id)
+	}
+
+	if d.cfg.spoolDir != nil {
+		if err := d.cfg.spoolDir.Settle(id, delivered); err != nil {
+			d.logger.Errorf("spool directory: %s", err.Error())
+		}
+	}
+}
+
+// saveCheckpoints saves the checkpoints periodically
+// until the delivery is stopped.
+func (d *delivery) saveCheckpoints() {
+	defer d.wg.Done()
+
+	t := time.NewTicker(checkpointInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := d.cfg.checkpoints.Save(); err != nil {
+				d.logger.Errorf("save checkpoints: %s", err.Error())
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// notifyRecord hands the message of the record to the
+// client along with its metadata.
+//
+// The key is sent in the X-Message-Key header.
+func (d *delivery) notifyRecord(rec input.Record) error {
+	var opts []notification.MessageOpt
+	if rec.ID != "" {
+		opts = append(opts, notification.WithMessageID(rec.ID))
+	}
+	if rec.Key != "" {
+		opts = append(opts, notification.WithHeader(keyHeader, rec.Key))
+	}
+	if rec.Priority != 0 {
+		opts = append(opts, notification.WithPriority(rec.Priority))
+	}
+
+	return d.client.NotifyWith(rec.Message, opts...)
+}
+
+// reject records messages that were never handed
+// to the client.
+func (d *delivery) reject(recs ...input.Record) {
+	d.drop(recs...)
+	for _, rec := range recs {
+		d.settle(rec.ID, false)
+	}
+}
+
+// drop records messages that were never handed to the
+// client without settling them, since their ID belongs
+// to another message that is still pending.
+func (d *delivery) drop(recs ...input.Record) {
+	atomic.AddUint64(&d.rejected, uint64(len(recs)))
+	for _, rec := range recs {
+		d.record(rec.Message)
+	}
+}
+
+// record writes an undelivered message to the
+// configured writer, or logs it if there is none.
+func (d *delivery) record(msg notification.Message) {
+	if d.cfg.undelivered == nil {
+		d.logger.Warnf("undelivered message: %q", msg)
+		return
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if _, err := fmt.Fprintln(d.cfg.undelivered, msg); err != nil {
+		d.logger.Errorf("record undelivered message %q: %s", msg, err.Error())
+	}
+}
+
+// undelivered returns the number of messages that
+// were not delivered.
+//
+// It is only accurate once the delivery was stopped.
+func (d *delivery) undelivered() uint64 {
+	stats := d.client.Stats()
+
+	return atomic.LoadUint64(&d.rejected) + stats.Failed + stats.Dropped
+}
+
+// stop waits for the client to deliver pending messages
+// until ctx is done, then stops it and waits for its
+// events to be handled.
+//
+// An error here is only indicative that
+// we couldn't shut down the client gracefully.
+func (d *delivery) stop(ctx context.Context) error {
+	if err := d.client.Drain(ctx); err != nil {
+		d.logger.Printf("drain: %s\n", err.Error())
+	}
+
+	err := d.client.Stop()
+	close(d.done)
+	d.wg.Wait()
+
+	// Saved once all events were handled.
+	if d.cfg.checkpoints != nil {
+		if err := d.cfg.checkpoints.Save(); err != nil {
+			d.logger.Errorf("save checkpoints: %s", err.Error())
+		}
+	}
+
+	if n := d.sub.Dropped(); n > 0 {
+		d.logger.Warnf("%d client events were missed, undelivered messages may not be recorded", n)
+	}
+
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+
+	return nil
+}
@@ -1,42 +1,175 @@
 package cli
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/prometheus/client_golang/prometheus"
-	log "github.com/sirupsen/logrus"
-	"github.com/urfave/cli/v2"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vivangkumar/notify/cmd/internal/checkpoint"
+	"github.com/vivangkumar/notify/cmd/internal/input"
+	"github.com/vivangkumar/notify/cmd/internal/listen"
+	"github.com/vivangkumar/notify/cmd/internal/spool"
+	"github.com/vivangkumar/notify/cmd/internal/spooldir"
+	"github.com/vivangkumar/notify/cmd/internal/tail"
 	"github.com/vivangkumar/notify/pkg/notification"
 )
 
+// spoolRetryInterval is the interval at which spooled
+// messages are replayed while waiting for them to be sent.
+const spoolRetryInterval = 100 * time.Millisecond
+
+// followInterval is the interval at which followed files
+// are checked for changes, and for new files to follow.
+const followInterval = 250 * time.Millisecond
+
+// locator returns the key of the file that the input was
+// read from at the given position, and the offset in the
+// file that corresponds to it.
+type locator func(pos int64) (file string, offset int64)
+
+// onFullMode determines what happens to messages that
+// do not fit in the buffer or the client queue.
+type onFullMode string
+
+const (
+	// onFullDrop drops messages that do not fit.
+	onFullDrop onFullMode = "drop"
+
+	// onFullBlock stops reading the input until there is
+	// room, which slows down the upstream pipe.
+	onFullBlock onFullMode = "block"
+
+	// onFullSpill writes messages that do not fit to
+	// a spool file, from which they are replayed later.
+	onFullSpill onFullMode = "spill"
+)
+
+// parseOnFullMode returns the mode with the given name.
+func parseOnFullMode(s string) (onFullMode, error) {
+	switch m := onFullMode(s); m {
+	case onFullDrop, onFullBlock, onFullSpill:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid on-full mode %q, must be one of drop, block or spill", s)
+	}
+}
+
+// temporaryError is implemented by errors of the client
+// that are resolved by retrying later.
+type temporaryError interface {
+	IsTemporary() bool
+	RetryAfter() time.Duration
+}
+
 type notificationClient interface {
-	Notify(msgs ...notification.Message) error
+	NotifyWith(msg notification.Message, opts ...notification.MessageOpt) error
 	Start()
+	Drain(ctx context.Context) error
 	Stop() error
-	Errors() <-chan error
+	Stats() notification.Stats
+	Subscribe(opts ...notification.SubscribeOpt) *notification.Subscription
 	MetricsRegistry() *prometheus.Registry
 }
 
 type timedBuffer interface {
-	Append(msgs ...notification.Message) error
+	Append(recs ...input.Record) error
+	AppendWait(ctx context.Context, recs ...input.Record) error
 	Close()
-	FlushCh() <-chan []notification.Message
+	Drain() []input.Record
+	FlushCh() <-chan []input.Record
+}
+
+// notifierConfig configures where the notifier reads
+// messages from and how it shuts down.
+type notifierConfig struct {
+	// input configures how records are read.
+	input input.Options
+
+	// stdin is read when no other input is set.
+	stdin io.Reader
+
+	// inputs are the files that are read instead of
+	// stdin, in order.
+	//
+	// With follow, they are the patterns of the
+	// files that are followed concurrently.
+	inputs []string
+
+	// follow is set if inputs are followed as
+	// they are written to.
+	follow bool
+
+	// spoolDir is the directory files are picked up
+	// from instead of stdin, if set.
+	spoolDir *spooldir.Dir
+
+	// listeners receive messages from producers
+	// instead of stdin, if set.
+	listeners []*listen.Listener
+
+	// shutdownTimeout is the time given to the client
+	// to deliver pending messages when shutting down.
+	shutdownTimeout time.Duration
+
+	// eventBufferSize is the number of failures that
+	// are buffered until they are recorded.
+	eventBufferSize int
+
+	// undelivered receives messages that were not
+	// delivered, one per line.
+	//
+	// They are logged if it is nil.
+	undelivered io.Writer
+
+	// onFull determines what happens to messages
+	// that do not fit.
+	onFull onFullMode
+
+	// spool holds messages that did not fit
+	// when onFull is onFullSpill.
+	spool *spool.Spool[input.Record]
+
+	// checkpoints records how far input files were
+	// read and their messages handled, if set.
+	checkpoints *checkpoint.Store
 }
 
 // notifier represents a component that makes use of
 // the notification client.
 //
-// It reads stdin and forwards the lines read to a buffer
-// which is flushed every "interval" that is configured.
+// It reads records from stdin or files and forwards them to
+// a buffer which is flushed every "interval" that is configured.
 type notifier struct {
-	client notificationClient
+	// seq numbers the IDs given to records without one.
+	//
+	// It is accessed atomically and kept first to
+	// ensure 64-bit alignment.
+	seq uint64
+
+	// delivery hands the records to the client
+	// and records the ones not delivered.
+	*delivery
+
 	buffer timedBuffer
+	cfg    notifierConfig
 
-	// keep track of our go routines
-	wg sync.WaitGroup
+	// eof is closed once all of the input was read.
+	eof chan struct{}
+
+	// readErr is the first error that stopped
+	// reading an input, if any.
+	readErr error
+
+	// m guards readErr.
+	m sync.Mutex
 
 	logger *log.Logger
 }
@@ -44,69 +177,403 @@ type notifier struct {
 func newNotifier(
 	client notificationClient,
 	buffer timedBuffer,
+	cfg notifierConfig,
 	logger *log.Logger,
 ) *notifier {
+	d := newDelivery(client, deliveryConfig{
+		eventBufferSize: cfg.eventBufferSize,
+		undelivered:     cfg.undelivered,
+		checkpoints:     cfg.checkpoints,
+		spoolDir:        cfg.spoolDir,
+	}, logger)
+
 	return &notifier{
-		client: client,
-		buffer: buffer,
-		logger: logger,
+		delivery: d,
+		buffer:   buffer,
+		cfg:      cfg,
+		eof:      make(chan struct{}),
+		logger:   logger,
 	}
 }
 
 // start runs the notifier.
 //
-// It spins up two go routines:
-//   One to scan for new lines from stdin.
-//   One to log errors from the notification client.
+// It starts the client, then spins up a go routine
+// to scan for new records from the input.
 //
 // After that, it starts a blocking operation
 // that waits on new messages to arrive so that they can
-// be sent as notifications.
-func (n *notifier) start(ctx *cli.Context) {
-	go n.scan()
-	go n.errors()
-	n.client.Start()
+// be sent as notifications, until the input is exhausted
+// or the context is done.
+func (n *notifier) start(ctx context.Context) {
+	n.delivery.start()
+
+	go n.scan(ctx)
+
+	// Replay what was spooled by a previous run.
+	n.replay()
 
 	n.notify(ctx)
 }
 
-// scan reads from stdin.
+// scan reads records from the input, which is either stdin,
+// the input files in order, the followed input files, the
+// files that appear in the spool directory, or producers
+// connected to the listeners.
 //
-// It will exit if all lines from stdin have been exhausted
-// or if there is an error when reading from stdin.
+// It will exit once all of the input has been exhausted. An
+// error reading an input only ends that input, and what was
+// read from it is still delivered. Records that cannot be
+// parsed or are too large are rejected.
 //
 // It continues to block in case of a long-running operation
 // or waiting for user input, in which case it expects an EOF
-// to gracefully exit.
-func (n *notifier) scan() {
-	n.logger.Println("reading stdin...")
+// to gracefully exit. Followed files, the spool directory
+// and listeners are read until the context is done.
+//
+// It is not waited on when stopping, since reading from
+// stdin cannot be interrupted.
+func (n *notifier) scan(ctx context.Context) {
+	switch {
+	case n.cfg.spoolDir != nil:
+		n.watchSpoolDir(ctx)
+	case len(n.cfg.listeners) > 0:
+		n.listen(ctx)
+	case n.cfg.follow:
+		n.follow(ctx)
+	case len(n.cfg.inputs) > 0:
+		for _, path := range n.cfg.inputs {
+			if ctx.Err() != nil {
+				break
+			}
+			n.readFile(ctx, path)
+		}
+	default:
+		n.logger.Println("reading stdin...")
+		if err := n.read(ctx, "stdin", n.cfg.stdin, nil); err != nil {
+			n.fail(err)
+		}
+	}
+
+	n.logger.Println("reached end of input")
+	close(n.eof)
+}
+
+// readFile reads records from the file at path.
+func (n *notifier) readFile(ctx context.Context, path string) {
+	n.logger.Printf("reading %s...\n", path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		n.fail(err)
+		return
+	}
+	defer f.Close()
+
+	var locate locator
+	if n.cfg.checkpoints != nil {
+		file, start := n.resume(f)
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			n.fail(err)
+			return
+		}
+
+		locate = func(pos int64) (string, int64) {
+			return file, start + pos
+		}
+	}
+
+	if err := n.read(ctx, path, f, locate); err != nil {
+		n.fail(err)
+	}
+}
+
+// follow reads records from the files that match the
+// input patterns as they are written to, until ctx is done.
+//
+// Files that are created later on are followed as well.
+func (n *notifier) follow(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	err := tail.Watch(ctx, n.cfg.inputs, followInterval, func(path string) {
+		n.logger.Printf("following %s...\n", path)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var opts []tail.Opt
+			if n.cfg.checkpoints != nil {
+				opts = append(opts, tail.WithResume(n.resume))
+			}
+
+			f := tail.Follow(ctx, path, followInterval, opts...)
+			defer f.Close()
+
+			var locate locator
+			if n.cfg.checkpoints != nil {
+				locate = f.Locate
+			}
+
+			if err := n.read(ctx, path, f, locate); err != nil {
+				n.fail(err)
+			}
+		}()
+	})
+	if err != nil {
+		n.fail(err)
+	}
+}
+
+// resume returns the key that identifies the file and
+// the offset its checkpoint is at.
+//
+// Files that cannot be identified are read from the start
+// and not checkpointed.
+func (n *notifier) resume(f *os.File) (string, int64) {
+	file, err := checkpoint.FileID(f)
+	if err != nil {
+		n.logger.Errorf("identify %s: %s", f.Name(), err.Error())
+		return "", 0
+	}
+
+	offset := n.cfg.checkpoints.Offset(file)
+
+	// The file was replaced or truncated since.
+	if info, err := f.Stat(); err != nil || offset > info.Size() {
+		offset = 0
+	}
+	if offset > 0 {
+		n.logger.Printf("resuming %s at offset %d\n", f.Name(), offset)
+	}
+
+	return file, offset
+}
+
+// watchSpoolDir reads records from the files that appear in
+// the spool directory, one file at a time, until ctx is done.
+//
+// Files are moved aside once their records were handled.
+// Files that are not read completely are left in place, so
+// that they are read again by the next run.
+func (n *notifier) watchSpoolDir(ctx context.Context) {
+	err := n.cfg.spoolDir.Watch(ctx, followInterval, func(path string) {
+		n.logger.Printf("reading %s...\n", path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			n.fail(err)
+			n.seal(path, false)
+			return
+		}
+		defer f.Close()
+
+		err = n.read(ctx, path, f, nil)
+		if err != nil {
+			n.fail(err)
+		}
+		if ctx.Err() == nil {
+			n.seal(path, err == nil)
+		}
+	})
+	if err != nil {
+		n.fail(err)
+	}
+}
+
+// listen reads records from the producers connected to the
+// listeners, until ctx is done.
+//
+// Every connection is read on its own, so that in block
+// mode only the producers that send while the buffer is
+// full are held up. Errors reading from a producer only
+// end its connection.
+func (n *notifier) listen(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, l := range n.cfg.listeners {
+		l := l
+		n.logger.Printf("listening on %s...\n", l.Addr())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := l.Serve(ctx, func(name string, r io.Reader) {
+				if err := n.read(ctx, name, r, nil); err != nil {
+					n.logger.Printf("read input: %s\n", err.Error())
+				}
+			})
+			if err != nil {
+				n.fail(fmt.Errorf("listen on %s: %w", l.Addr(), err))
+			}
+		}()
+	}
+}
 
-	n.wg.Add(1)
-	defer n.wg.Done()
+// seal records that all records of the spooled file
+// at path were read, and whether that succeeded.
+func (n *notifier) seal(path string, ok bool) {
+	if err := n.cfg.spoolDir.Seal(path, ok); err != nil {
+		n.logger.Errorf("spool directory: %s", err.Error())
+	}
+}
+
+// read reads records from r until it is exhausted,
+// or ctx is done.
+//
+// If locate is not nil, records are tracked so that the
+// checkpoint of the file advances once they are handled.
+// Records of spooled files are always tracked.
+//
+// It returns the error that stopped reading, if any. Errors
+// after ctx is done are not returned, since they are likely
+// caused by closing r.
+func (n *notifier) read(ctx context.Context, name string, r io.Reader, locate locator) error {
+	// The options were validated when starting.
+	in, _ := input.NewReader(r, n.cfg.input)
+
+	for ctx.Err() == nil {
+		rec, err := in.Read()
+		if err == io.EOF {
+			return nil
+		}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		err := n.buffer.Append(scanner.Text())
+		var ire *input.InvalidRecordError
+		if errors.As(err, &ire) {
+			n.logger.Printf("read %s: %s\n", name, err.Error())
+
+			rec := input.Record{Message: ire.Raw}
+			if err := n.track(&rec, name, in.Offset(), locate); err != nil {
+				n.logger.Printf("read %s: message %q: %s\n", name, rec.ID, err.Error())
+				n.drop(rec)
+				continue
+			}
+			n.reject(rec)
+			continue
+		}
+
+		// Stop reading, but deliver what was read
+		// so far as if the input had ended.
 		if err != nil {
-			n.logger.Printf("buffer append: %s\n", err.Error())
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if rec.Truncated {
+			n.logger.Printf("read %s: message truncated to %d bytes\n", name, len(rec.Message))
+		}
+
+		if err := n.track(&rec, name, in.Offset(), locate); err != nil {
+			n.logger.Printf("read %s: message %q: %s\n", name, rec.ID, err.Error())
+			n.drop(rec)
+			continue
+		}
+		n.append(ctx, rec)
+	}
+
+	return nil
+}
+
+// fail records an error reading the input.
+func (n *notifier) fail(err error) {
+	n.logger.Printf("read input: %s\n", err.Error())
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.readErr == nil {
+		n.readErr = err
+	}
+}
+
+// readError returns the first error reading
+// the input, if any.
+func (n *notifier) readError() error {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	return n.readErr
+}
+
+// track records that the record was read from the file at
+// path, so that the file is moved once the record is settled
+// if it is spooled, or its checkpoint is advanced otherwise.
+//
+// Records without an ID are given a unique one, so that
+// they can be settled. Records whose ID is shared by one that
+// was not settled yet cannot be, and an error is returned.
+func (n *notifier) track(rec *input.Record, path string, pos int64, locate locator) error {
+	if n.cfg.spoolDir != nil {
+		if rec.ID == "" {
+			rec.ID = n.nextID(path, pos)
 		}
+		return n.cfg.spoolDir.Track(rec.ID, path)
+	}
+
+	if locate == nil {
+		return nil
 	}
 
-	// Kill the program if there is an error reading
-	// from stdin.
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("scanner: %s", err.Error())
+	file, offset := locate(pos)
+	if file == "" {
+		return nil
 	}
+
+	if rec.ID == "" {
+		rec.ID = n.nextID(file, offset)
+	}
+	return n.cfg.checkpoints.Track(rec.ID, file, path, offset)
+}
+
+// nextID returns an ID for a record read up to pos from
+// the file, numbered so that it stays unique if the file is
+// truncated and the same position is read again.
+func (n *notifier) nextID(file string, pos int64) string {
+	return fmt.Sprintf("%s:%d#%d", file, pos, atomic.AddUint64(&n.seq, 1))
 }
 
-// errors logs errors returned from the client.
-func (n *notifier) errors() {
-	n.wg.Add(1)
-	defer n.wg.Done()
+// append adds a record to the buffer.
+//
+// If the buffer is full, it waits for the buffer to be
+// flushed in block mode. Otherwise, the record overflows.
+func (n *notifier) append(ctx context.Context, rec input.Record) {
+	var err error
+	if n.cfg.onFull == onFullBlock {
+		err = n.buffer.AppendWait(ctx, rec)
+	} else {
+		err = n.buffer.Append(rec)
+	}
+
+	if err != nil {
+		n.logger.Printf("buffer append: %s\n", err.Error())
+		n.overflow(rec)
+	}
+}
 
-	for err := range n.client.Errors() {
-		n.logger.Printf("client error: %s\n", err.Error())
+// overflow handles records that did not fit.
+//
+// They are written to the spool in spill mode and
+// rejected otherwise.
+func (n *notifier) overflow(recs ...input.Record) {
+	if n.cfg.onFull == onFullSpill {
+		err := n.cfg.spool.Write(recs...)
+		if err == nil {
+			// Spooled messages are kept
+			// across runs.
+			for _, rec := range recs {
+				n.settle(rec.ID, true)
+			}
+			return
+		}
+		n.logger.Errorf("spill messages: %s", err.Error())
 	}
+
+	n.reject(recs...)
 }
 
 // notify waits for messages from the buffer
@@ -114,17 +581,31 @@ func (n *notifier) errors() {
 // via the client.
 //
 // It is also responsible for receiving the context done event.
-func (n *notifier) notify(ctx *cli.Context) {
+//
+// Once the input is exhausted, what is left in the buffer is
+// sent and it waits for the client to deliver all messages.
+func (n *notifier) notify(ctx context.Context) {
 	n.logger.Println("waiting for messages...")
 
 	for {
 		select {
-		case msgs := <-n.buffer.FlushCh():
-			n.logger.Printf("sending messages: %v\n", msgs)
-			err := n.client.Notify(msgs...)
-			if err != nil {
-				n.logger.Printf("message queue error: %s\n", err.Error())
+		case recs := <-n.buffer.FlushCh():
+			n.send(ctx, recs)
+			n.replay()
+		case <-n.eof:
+			n.send(ctx, n.buffer.Drain())
+
+			// The client may never make room for spooled
+			// messages, which are kept for the next run.
+			rctx, cancel := context.WithTimeout(ctx, n.cfg.shutdownTimeout)
+			n.replayAll(rctx)
+			cancel()
+
+			n.logger.Println("waiting for messages to be delivered...")
+			if err := n.client.Drain(ctx); err != nil {
+				n.logger.Printf("drain: %s\n", err.Error())
 			}
+			return
 		case <-ctx.Done():
 			n.logger.Printf("received interrupt...")
 			return
@@ -132,20 +613,129 @@ func (n *notifier) notify(ctx *cli.Context) {
 	}
 }
 
+// send hands the records to the client.
+func (n *notifier) send(ctx context.Context, recs []input.Record) {
+	if len(recs) == 0 {
+		return
+	}
+
+	n.logger.Printf("sending %d messages\n", len(recs))
+	for i, rec := range recs {
+		if err := n.enqueue(ctx, rec); err != nil {
+			// Messages after the one that was refused
+			// are not sent either.
+			n.overflow(recs[i:]...)
+			n.logger.Printf("message queue error: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+// enqueue hands a single record to the client.
+//
+// In block mode, it retries messages the client refused
+// because its queue was full, until ctx is done.
+func (n *notifier) enqueue(ctx context.Context, rec input.Record) error {
+	for {
+		err := n.notifyRecord(rec)
+
+		var te temporaryError
+		if err == nil || n.cfg.onFull != onFullBlock ||
+			!errors.As(err, &te) || !te.IsTemporary() {
+			return err
+		}
+
+		select {
+		case <-time.After(te.RetryAfter()):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// replay hands spooled messages to the client
+// until its queue is full.
+func (n *notifier) replay() {
+	if n.cfg.spool == nil {
+		return
+	}
+
+	replayed, err := n.cfg.spool.Replay(n.notifyRecord)
+	if err != nil {
+		n.logger.Errorf("replay spool: %s", err.Error())
+	}
+
+	// Values that cannot be decoded were removed from
+	// the spool, and are recorded as they were spilled.
+	var ive *spool.InvalidValuesError
+	if errors.As(err, &ive) {
+		for _, raw := range ive.Raw {
+			n.drop(input.Record{Message: notification.Message(raw)})
+		}
+	}
+	if replayed > 0 {
+		n.logger.Printf("replayed %d spooled messages\n", replayed)
+	}
+}
+
+// replayAll replays spooled messages until the
+// spool is empty or ctx is done.
+func (n *notifier) replayAll(ctx context.Context) {
+	if n.cfg.spool == nil {
+		return
+	}
+
+	for {
+		n.replay()
+		if n.cfg.spool.Len() == 0 {
+			return
+		}
+
+		select {
+		case <-time.After(spoolRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// undelivered returns the number of messages read from
+// the input that were not delivered, including messages
+// left in the spool.
+//
+// It is only accurate once the notifier was stopped.
+func (n *notifier) undelivered() uint64 {
+	count := n.delivery.undelivered()
+	if n.cfg.spool != nil {
+		count += uint64(n.cfg.spool.Len())
+	}
+
+	return count
+}
+
 // stop gracefully shuts down the client
 // and waits for go routines to finish.
 //
+// Messages still in the buffer are sent, after which the
+// client is given the shutdown timeout to deliver pending
+// messages. Anything left undelivered is recorded.
+//
 // An error here is only indicative that
 // we couldn't shut down the client gracefully.
 func (n *notifier) stop() error {
 	n.buffer.Close()
 	n.logger.Println("stopping notifier...")
 
-	err := n.client.Stop()
-	if err != nil {
-		return fmt.Errorf("client: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.shutdownTimeout)
+	defer cancel()
+
+	n.send(ctx, n.buffer.Drain())
+	n.replayAll(ctx)
+	err := n.delivery.stop(ctx)
+
+	if n.cfg.spool != nil && n.cfg.spool.Len() > 0 {
+		n.logger.Warnf("%d messages left in spool", n.cfg.spool.Len())
 	}
 
-	n.wg.Wait()
-	return nil
+	return err
 }
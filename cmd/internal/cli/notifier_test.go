@@ -0,0 +1,502 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/vivangkumar/notify/cmd/internal/checkpoint"
+	"github.com/vivangkumar/notify/cmd/internal/input"
+	"github.com/vivangkumar/notify/cmd/internal/spool"
+	"github.com/vivangkumar/notify/cmd/internal/timedbuffer"
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+func TestNotifier_EOF(t *testing.T) {
+	t.Parallel()
+
+	r := newReceiver(t, 0)
+	n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+		stdin: strings.NewReader("a\nb\n"),
+	}, 10, time.Hour)
+
+	// The buffer is sent at the end of the input, long
+	// before the interval, and delivered before returning.
+	n.start(context.Background())
+	assert.ElementsMatch(t, []string{"a", "b"}, r.received())
+
+	assert.Equal(t, 0, exitCode(finish(n)))
+	assert.Empty(t, undelivered.String())
+}
+
+func TestNotifier_Undelivered(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			// Too large, so it is never sent.
+			name: "rejected",
+			in:   "abcdef\nb\n",
+			want: "abc\n",
+		},
+		{
+			// Refused by the receiver.
+			name: "failed",
+			in:   "bad\nb\n",
+			want: "bad\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newReceiver(t, 0)
+			n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+				input: input.Options{MaxSize: 3},
+				stdin: strings.NewReader(tt.in),
+			}, 10, time.Hour)
+
+			n.start(context.Background())
+			err := finish(n)
+
+			assert.Equal(t, exitCodeUndelivered, exitCode(err))
+			assert.Equal(t, tt.want, undelivered.String())
+			assert.Contains(t, r.received(), "b")
+		})
+	}
+}
+
+func TestNotifier_Stop(t *testing.T) {
+	t.Parallel()
+
+	r := newReceiver(t, 0)
+	stdin := newBlockingReader(t, "a\nb\n")
+	n, undelivered := newTestNotifier(t, r.URL, notifierConfig{stdin: stdin}, 10, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.start(ctx)
+		close(done)
+	}()
+
+	// Interrupt once both messages were buffered and
+	// stdin is waited on.
+	<-stdin.waiting
+	cancel()
+	<-done
+	assert.Empty(t, r.received())
+
+	// Stopping sends the buffered messages.
+	assert.Equal(t, 0, exitCode(finish(n)))
+	assert.ElementsMatch(t, []string{"a", "b"}, r.received())
+	assert.Empty(t, undelivered.String())
+}
+
+func TestNotifier_OnFull(t *testing.T) {
+	t.Parallel()
+
+	const in = "1\n2\n3\n4\n5\n"
+
+	t.Run("drop", func(t *testing.T) {
+		t.Parallel()
+
+		r := newReceiver(t, 50*time.Millisecond)
+		n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+			stdin:  strings.NewReader(in),
+			onFull: onFullDrop,
+		}, 1, 10*time.Millisecond, slowClientOpts...)
+
+		n.start(context.Background())
+
+		assert.Equal(t, exitCodeUndelivered, exitCode(finish(n)))
+		dropped := strings.Fields(undelivered.String())
+		assert.NotEmpty(t, dropped)
+		assert.ElementsMatch(t, strings.Fields(in), append(r.received(), dropped...))
+	})
+
+	t.Run("block", func(t *testing.T) {
+		t.Parallel()
+
+		r := newReceiver(t, 50*time.Millisecond)
+		n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+			stdin:  strings.NewReader(in),
+			onFull: onFullBlock,
+		}, 1, 10*time.Millisecond, slowClientOpts...)
+
+		done := make(chan struct{})
+		go func() {
+			n.start(context.Background())
+			close(done)
+		}()
+
+		// Reading is held while the buffer and the client
+		// are full, until the first message is delivered.
+		select {
+		case <-n.eof:
+			t.Fatal("input was read while the buffer was full")
+		case <-time.After(25 * time.Millisecond):
+		}
+		<-done
+
+		assert.Equal(t, 0, exitCode(finish(n)))
+		assert.ElementsMatch(t, strings.Fields(in), r.received())
+		assert.Empty(t, undelivered.String())
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := spool.Open[input.Record](filepath.Join(t.TempDir(), "spool"))
+		assert.Nil(t, err)
+		defer s.Close()
+
+		r := newReceiver(t, 0)
+		n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+			stdin:  strings.NewReader(in),
+			onFull: onFullSpill,
+			spool:  s,
+		}, 1, time.Hour)
+
+		// Only the first message fits in the buffer, the
+		// others are spilled and replayed at the end.
+		n.start(context.Background())
+
+		assert.Equal(t, 0, exitCode(finish(n)))
+		assert.Equal(t, uint64(0), atomic.LoadUint64(&n.rejected))
+		assert.ElementsMatch(t, strings.Fields(in), r.received())
+		assert.Equal(t, 0, s.Len())
+		assert.Empty(t, undelivered.String())
+	})
+}
+
+func TestNotifier_OnFull_Spill_Replay(t *testing.T) {
+	t.Parallel()
+
+	// Left in the spool by a previous run.
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := spool.Open[input.Record](path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write(input.Record{Message: "spilled"}))
+	assert.Nil(t, s.Close())
+
+	s, err = spool.Open[input.Record](path)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	r := newReceiver(t, 0)
+	n, _ := newTestNotifier(t, r.URL, notifierConfig{
+		stdin:  strings.NewReader("a\n"),
+		onFull: onFullSpill,
+		spool:  s,
+	}, 10, time.Hour)
+
+	n.start(context.Background())
+
+	assert.Equal(t, 0, exitCode(finish(n)))
+	assert.ElementsMatch(t, []string{"spilled", "a"}, r.received())
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestNotifier_OnFull_Spill_Invalid(t *testing.T) {
+	t.Parallel()
+
+	// A value that was corrupted in the spool.
+	path := filepath.Join(t.TempDir(), "spool")
+	data := "{\"message\":\"a\"}\n{\"message\":\n{\"message\":\"b\"}\n"
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+
+	s, err := spool.Open[input.Record](path)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	r := newReceiver(t, 0)
+	n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+		stdin:  strings.NewReader(""),
+		onFull: onFullSpill,
+		spool:  s,
+	}, 10, time.Hour)
+
+	// The values around it are still replayed.
+	n.start(context.Background())
+
+	assert.Equal(t, exitCodeUndelivered, exitCode(finish(n)))
+	assert.ElementsMatch(t, []string{"a", "b"}, r.received())
+	assert.Equal(t, "{\"message\":\n", undelivered.String())
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestNotifier_OnFull_Spill_EOF(t *testing.T) {
+	t.Parallel()
+
+	s, err := spool.Open[input.Record](filepath.Join(t.TempDir(), "spool"))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	r := newReceiver(t, 500*time.Millisecond)
+	n, _ := newTestNotifier(t, r.URL, notifierConfig{
+		stdin:  strings.NewReader("a\nb\nc\n"),
+		onFull: onFullSpill,
+		spool:  s,
+	}, 10, time.Hour, slowClientOpts...)
+	n.cfg.shutdownTimeout = 10 * time.Millisecond
+
+	// The client has no room for the spilled messages
+	// until the timeout elapsed, so they are kept rather
+	// than waited for.
+	n.start(context.Background())
+
+	assert.Equal(t, exitCodeUndelivered, exitCode(finish(n)))
+	assert.Greater(t, s.Len(), 0)
+	assert.Len(t, r.received(), 3-s.Len())
+}
+
+func TestNotifier_Checkpoints_Stop(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	assert.Nil(t, os.WriteFile(path, []byte("a\nb\n"), 0o644))
+
+	store, err := checkpoint.Open(filepath.Join(dir, "checkpoints.json"))
+	assert.Nil(t, err)
+
+	r := newReceiver(t, 0)
+	n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+		inputs:      []string{path},
+		checkpoints: store,
+	}, 10, time.Hour)
+	n.cfg.shutdownTimeout = 10 * time.Millisecond
+
+	// The messages are still queued when the notifier
+	// is stopped, so they are dropped.
+	client := n.client.(*notification.Client)
+	client.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.start(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return client.Stats().QueueLength == 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, exitCodeUndelivered, exitCode(finish(n)))
+	assert.Equal(t, "a\nb\n", undelivered.String())
+	assert.Empty(t, r.received())
+
+	// The offset did not move past the dropped messages.
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	id, err := checkpoint.FileID(f)
+	assert.Nil(t, err)
+
+	store, err = checkpoint.Open(filepath.Join(dir, "checkpoints.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), store.Offset(id))
+}
+
+func TestNotifier_Checkpoints_DuplicateID(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		`{"id":"1","message":"a"}`,
+		`{"id":"1","message":"b"}`,
+		`{"id":"2","message":"c"}`,
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+
+	store, err := checkpoint.Open(filepath.Join(dir, "checkpoints.json"))
+	assert.Nil(t, err)
+
+	r := newReceiver(t, 0)
+	n, undelivered := newTestNotifier(t, r.URL, notifierConfig{
+		input: input.Options{
+			Format: input.FormatNDJSON,
+			Fields: input.Fields{ID: "id"},
+		},
+		inputs:      []string{path},
+		checkpoints: store,
+	}, 10, time.Hour)
+
+	// The second message is not sent, since its
+	// ID is pending when it is read.
+	n.start(context.Background())
+	assert.ElementsMatch(t, []string{lines[0], lines[2]}, r.received())
+
+	assert.Equal(t, exitCodeUndelivered, exitCode(finish(n)))
+	assert.Equal(t, lines[1]+"\n", undelivered.String())
+
+	// The offset moved past the first message, which was
+	// delivered, but not past the duplicate.
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	id, err := checkpoint.FileID(f)
+	assert.Nil(t, err)
+
+	store, err = checkpoint.Open(filepath.Join(dir, "checkpoints.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(lines[0])+1), store.Offset(id))
+}
+
+// slowClientOpts configure a client that holds a
+// single message in its queue and sends one at a time.
+var slowClientOpts = []notification.Opt{
+	notification.WithMaxBufferSize(1),
+	notification.WithMaxConcurrency(1),
+}
+
+// newTestNotifier returns a notifier that sends to url,
+// along with the buffer undelivered messages are
+// recorded in.
+func newTestNotifier(
+	t *testing.T,
+	url string,
+	cfg notifierConfig,
+	bufferSize int,
+	interval time.Duration,
+	opts ...notification.Opt,
+) (*notifier, *bytes.Buffer) {
+	t.Helper()
+
+	client := notification.NewClient(url, opts...)
+	buffer := timedbuffer.NewOf[input.Record](interval, bufferSize)
+
+	var undelivered bytes.Buffer
+	cfg.undelivered = &undelivered
+	cfg.shutdownTimeout = 5 * time.Second
+	cfg.eventBufferSize = 100
+	if cfg.onFull == "" {
+		cfg.onFull = onFullDrop
+	}
+
+	return newNotifier(client, buffer, cfg, discardLogger()), &undelivered
+}
+
+func discardLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return logger
+}
+
+// exitCode returns the code the CLI exits with
+// when err is returned.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var ec cli.ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode()
+	}
+
+	return 1
+}
+
+// receiver records the messages it receives, after
+// waiting for delay. Messages starting with "bad" are
+// refused.
+type receiver struct {
+	*httptest.Server
+
+	m    sync.Mutex
+	msgs []string
+}
+
+func newReceiver(t *testing.T, delay time.Duration) *receiver {
+	t.Helper()
+
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		time.Sleep(delay)
+
+		if strings.HasPrefix(string(body), "bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		r.m.Lock()
+		r.msgs = append(r.msgs, string(body))
+		r.m.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) received() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]string(nil), r.msgs...)
+}
+
+// blockingReader returns data, after which reads block
+// until the test ends, like stdin that is kept open.
+type blockingReader struct {
+	data    io.Reader
+	waiting chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newBlockingReader(t *testing.T, data string) *blockingReader {
+	t.Helper()
+
+	r := &blockingReader{
+		data:    strings.NewReader(data),
+		waiting: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	t.Cleanup(func() { close(r.closed) })
+
+	return r
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+
+	r.once.Do(func() { close(r.waiting) })
+	<-r.closed
+
+	return 0, io.EOF
+}
@@ -0,0 +1,432 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+
+	"github.com/vivangkumar/notify/cmd/internal/input"
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+const (
+	addrFlag        = "addr"
+	maxBodySizeFlag = "max-body-size"
+)
+
+// readHeaderTimeout is the time given to clients
+// to send the headers of a request.
+const readHeaderTimeout = 10 * time.Second
+
+// serveCommand returns the command that relays
+// messages posted over HTTP.
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "relays messages posted to an HTTP API",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  addrFlag,
+				Value: ":8080",
+				Usage: "address to serve the HTTP API on",
+			},
+			&cli.Int64Flag{
+				Name:  maxBodySizeFlag,
+				Value: 10 << 20,
+				Usage: "max size of a request body in bytes",
+			},
+		},
+		Action: serve,
+	}
+}
+
+// serve is the entry point of the serve command.
+//
+// It serves the HTTP API until the context is done, after
+// which the requests in progress are finished and the
+// client is stopped like it is at the end of the input.
+func serve(ctx *cli.Context) error {
+	addr := ctx.String(addrFlag)
+	maxBodySize := ctx.Int64(maxBodySizeFlag)
+	maxMessageSize := ctx.Int(maxMessageSizeFlag)
+	shutdownTimeout := ctx.Duration(shutdownTimeoutFlag)
+	undeliveredFile := ctx.String(undeliveredFileFlag)
+
+	if maxBodySize <= 0 {
+		return fmt.Errorf("--%s must be positive", maxBodySizeFlag)
+	}
+	if maxMessageSize <= 0 {
+		return fmt.Errorf("--%s must be positive", maxMessageSizeFlag)
+	}
+
+	// Listen right away, so that an address
+	// that is taken is reported.
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	client, logger := newClient(ctx, notification.WithMetrics(nil))
+
+	cfg := deliveryConfig{
+		eventBufferSize: ctx.Int(maxBufferSizeFlag) + ctx.Int(maxConcurrencyFlag),
+	}
+	if undeliveredFile != "" {
+		f, err := openUndelivered(undeliveredFile)
+		if err != nil {
+			_ = l.Close()
+			return err
+		}
+		defer f.Close()
+
+		cfg.undelivered = f
+	}
+
+	d := newDelivery(client, cfg, logger)
+	d.start()
+
+	srv := &http.Server{
+		Handler:           newServer(d, maxBodySize, maxMessageSize).handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	logger.Printf("serving on %s\n", l.Addr())
+
+	select {
+	case <-ctx.Done():
+		logger.Printf("received interrupt...")
+	case err := <-errs:
+		logger.Errorf("serve: %s", err.Error())
+	}
+
+	// Finish the requests in progress, so that their
+	// messages are handed to the client before it stops.
+	// Both share the shutdown timeout.
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		logger.Printf("shutdown server: %s\n", err.Error())
+	}
+
+	if err := d.stop(sctx); err != nil {
+		return fmt.Errorf("notifier: %w", err)
+	}
+
+	return exitUndelivered(d.undelivered())
+}
+
+// server relays messages posted to its HTTP API
+// through the client.
+type server struct {
+	d *delivery
+
+	// maxBodySize is the max size of a request body.
+	maxBodySize int64
+
+	// maxMessageSize is the max size of a message.
+	maxMessageSize int
+}
+
+func newServer(d *delivery, maxBodySize int64, maxMessageSize int) *server {
+	return &server{
+		d:              d,
+		maxBodySize:    maxBodySize,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// handler returns the handler of the HTTP API.
+//
+// POST /messages accepts messages, GET /metrics exposes
+// the metrics of the client and GET /healthz reports
+// that the server is up.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", s.messages)
+	mux.Handle("/metrics", promhttp.HandlerFor(
+		s.d.client.MetricsRegistry(),
+		promhttp.HandlerOpts{},
+	))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return mux
+}
+
+// message is a message posted to POST /messages.
+type message struct {
+	Message  notification.Message `json:"message"`
+	ID       string               `json:"id"`
+	Key      string               `json:"key"`
+	Priority int                  `json:"priority"`
+}
+
+// record returns the record the message is
+// handed to the client as.
+func (m message) record() input.Record {
+	return input.Record{
+		Message:  m.Message,
+		ID:       m.ID,
+		Key:      m.Key,
+		Priority: m.Priority,
+	}
+}
+
+// response is the body of a response to
+// POST /messages.
+type response struct {
+	// IDs are the IDs of the messages that were
+	// accepted, in the order they were posted.
+	IDs []string `json:"ids"`
+
+	// Error describes why the request, or the
+	// messages after the accepted ones, failed.
+	Error string `json:"error,omitempty"`
+}
+
+// httpError is an error with the status code
+// it is responded to with.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func newHTTPError(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// messages handles POST /messages.
+//
+// The messages of the request are handed to the client in
+// order. It responds with 202 and the IDs of the messages
+// once all of them were accepted.
+//
+// If the client refuses a message, it responds with 429, or
+// 503 if the client is paused, along with a Retry-After
+// header. The messages before it were accepted, and their
+// IDs are returned, while the ones after it were not.
+func (s *server) messages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.respond(w, http.StatusMethodNotAllowed, response{Error: "method not allowed"})
+		return
+	}
+
+	msgs, err := s.parse(r)
+	if err != nil {
+		code := http.StatusBadRequest
+		var he *httpError
+		if errors.As(err, &he) {
+			code = he.code
+		}
+		s.respond(w, code, response{Error: err.Error()})
+		return
+	}
+
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.ID == "" {
+			id, err := newMessageID()
+			if err != nil {
+				s.d.logger.Errorf("message id: %s", err.Error())
+				s.respond(w, http.StatusInternalServerError, response{IDs: ids, Error: err.Error()})
+				return
+			}
+			msg.ID = id
+		}
+
+		err := s.d.notifyRecord(msg.record())
+		if err == nil {
+			ids = append(ids, msg.ID)
+			continue
+		}
+
+		code := http.StatusServiceUnavailable
+		var te temporaryError
+		if errors.As(err, &te) && te.IsTemporary() {
+			if !s.d.client.Stats().Paused {
+				code = http.StatusTooManyRequests
+			}
+			w.Header().Set("Retry-After", retryAfter(te.RetryAfter()))
+		}
+
+		s.d.logger.Printf("message queue error: %s\n", err.Error())
+		s.respond(w, code, response{IDs: ids, Error: err.Error()})
+		return
+	}
+
+	s.respond(w, http.StatusAccepted, response{IDs: ids})
+}
+
+// parse reads the messages of the request.
+//
+// The body is a message or an array of messages with
+// application/json, a message per line with
+// application/x-ndjson, and a single message
+// with text/plain.
+func (s *server) parse(r *http.Request) ([]message, error) {
+	typ, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, newHTTPError(http.StatusUnsupportedMediaType, "invalid content type: %w", err)
+	}
+
+	// Read one byte more than allowed to
+	// tell if the body is too large.
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if int64(len(body)) > s.maxBodySize {
+		return nil, newHTTPError(http.StatusRequestEntityTooLarge, "body exceeds %d bytes", s.maxBodySize)
+	}
+
+	var msgs []message
+	switch typ {
+	case "application/json":
+		msgs, err = parseJSON(body)
+	case "application/x-ndjson", "application/ndjson":
+		msgs, err = parseNDJSON(body)
+	case "text/plain":
+		msgs = []message{{Message: notification.Message(body)}}
+	default:
+		return nil, newHTTPError(http.StatusUnsupportedMediaType, "unsupported content type %q", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msgs) == 0 {
+		return nil, errors.New("no messages")
+	}
+	for i, msg := range msgs {
+		if msg.Message == "" {
+			return nil, fmt.Errorf("message %d is empty", i)
+		}
+		if len(msg.Message) > s.maxMessageSize {
+			return nil, newHTTPError(
+				http.StatusRequestEntityTooLarge,
+				"message %d exceeds %d bytes", i, s.maxMessageSize,
+			)
+		}
+	}
+
+	return msgs, nil
+}
+
+// parseJSON parses a message, or an array of messages.
+func parseJSON(body []byte) ([]message, error) {
+	body = bytes.TrimSpace(body)
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		var msgs []message
+		if err := decodeMessage(body, &msgs); err != nil {
+			return nil, err
+		}
+		return msgs, nil
+	}
+
+	var msg message
+	if err := decodeMessage(body, &msg); err != nil {
+		return nil, err
+	}
+
+	return []message{msg}, nil
+}
+
+// parseNDJSON parses a message per line, skipping
+// blank lines.
+func parseNDJSON(body []byte) ([]message, error) {
+	var msgs []message
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(nil, len(body)+1)
+	for line := 1; sc.Scan(); line++ {
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var msg message
+		if err := decodeMessage(raw, &msg); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, sc.Err()
+}
+
+// decodeMessage decodes messages, rejecting
+// fields that are unknown.
+func decodeMessage(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if dec.More() {
+		return errors.New("invalid message: unexpected data after the message")
+	}
+
+	return nil
+}
+
+// respond writes the response as JSON.
+func (s *server) respond(w http.ResponseWriter, code int, resp response) {
+	if resp.IDs == nil {
+		resp.IDs = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.d.logger.Printf("write response: %s\n", err.Error())
+	}
+}
+
+// retryAfter formats the duration as the value of
+// a Retry-After header, in whole seconds.
+func retryAfter(d time.Duration) string {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+
+	return strconv.FormatInt(secs, 10)
+}
+
+// newMessageID returns a random message ID for
+// messages that were posted without one.
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+func TestServer_Messages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		typ  string
+		body string
+		ids  []string
+		want []string
+	}{
+		{
+			name: "json",
+			typ:  "application/json",
+			body: `{"id":"1","message":"a"}`,
+			ids:  []string{"1"},
+			want: []string{"a"},
+		},
+		{
+			name: "json array",
+			typ:  "application/json; charset=utf-8",
+			body: ` [{"id":"1","message":"a"},{"message":"b"}] `,
+			ids:  []string{"1", ""},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "ndjson",
+			typ:  "application/x-ndjson",
+			body: "{\"message\":\"a\"}\n\n  \n{\"id\":\"2\",\"message\":\"b\"}\n",
+			ids:  []string{"", "2"},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "text",
+			typ:  "text/plain",
+			body: "a b\nc",
+			ids:  []string{""},
+			want: []string{"a b\nc"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newReceiver(t, 0)
+			d, undelivered := newTestDelivery(t, r.URL)
+			d.start()
+
+			rec, resp := post(t, newServer(d, 1024, 16).handler(), tt.typ, tt.body)
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Empty(t, resp.Error)
+
+			// Messages posted without an ID are given one.
+			assert.Len(t, resp.IDs, len(tt.ids))
+			for i, id := range resp.IDs {
+				if tt.ids[i] == "" {
+					assert.Len(t, id, 32)
+				} else {
+					assert.Equal(t, tt.ids[i], id)
+				}
+			}
+
+			assert.Nil(t, d.stop(context.Background()))
+			assert.Equal(t, uint64(0), d.undelivered())
+			assert.ElementsMatch(t, tt.want, r.received())
+			assert.Empty(t, undelivered.String())
+		})
+	}
+}
+
+func TestServer_Messages_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		typ  string
+		body string
+		code int
+	}{
+		{
+			name: "unknown content type",
+			typ:  "application/xml",
+			body: "<message>a</message>",
+			code: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "no content type",
+			body: "a",
+			code: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "body too large",
+			typ:  "text/plain",
+			body: strings.Repeat("a", 65),
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "message too large",
+			typ:  "application/json",
+			body: `[{"message":"a"},{"message":"abcdefghi"}]`,
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "unknown field",
+			typ:  "application/json",
+			body: `{"message":"a","body":"b"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "internal field",
+			typ:  "application/json",
+			body: `{"message":"a","truncated":true}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "trailing data",
+			typ:  "application/json",
+			body: `{"message":"a"} {"message":"b"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "trailing data ndjson",
+			typ:  "application/x-ndjson",
+			body: "{\"message\":\"a\"} {\"message\":\"b\"}\n",
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "empty message",
+			typ:  "application/json",
+			body: `{"message":""}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "no messages",
+			typ:  "application/json",
+			body: `[]`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Nothing is handed to the client, which
+			// is not started.
+			d, _ := newTestDelivery(t, "http://localhost")
+
+			rec, resp := post(t, newServer(d, 64, 8).handler(), tt.typ, tt.body)
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Empty(t, resp.IDs)
+			assert.NotEmpty(t, resp.Error)
+			assert.Equal(t, 0, d.client.Stats().QueueLength)
+		})
+	}
+}
+
+func TestServer_Messages_Refused(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		paused bool
+		code   int
+	}{
+		{
+			name: "full",
+			code: http.StatusTooManyRequests,
+		},
+		{
+			name:   "paused",
+			paused: true,
+			code:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The client is not started, so its queue
+			// fills up after two messages.
+			d, _ := newTestDelivery(t, "http://localhost", notification.WithMaxBufferSize(2))
+			if tt.paused {
+				d.client.(*notification.Client).Pause()
+			}
+
+			rec, resp := post(
+				t, newServer(d, 1024, 16).handler(), "application/json",
+				`[{"id":"1","message":"a"},{"id":"2","message":"b"},{"id":"3","message":"c"}]`,
+			)
+			assert.Equal(t, tt.code, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+			assert.NotEmpty(t, resp.Error)
+
+			// The messages before the refused one
+			// were accepted.
+			assert.Equal(t, []string{"1", "2"}, resp.IDs)
+			assert.Equal(t, 2, d.client.Stats().QueueLength)
+		})
+	}
+}
+
+func TestServer_Messages_Method(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDelivery(t, "http://localhost")
+
+	req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+	rec := httptest.NewRecorder()
+	newServer(d, 1024, 16).handler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+}
+
+func TestServer_Healthz(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDelivery(t, "http://localhost")
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	newServer(d, 1024, 16).handler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// newTestDelivery returns a delivery that sends to url,
+// along with the buffer undelivered messages are
+// recorded in.
+func newTestDelivery(t *testing.T, url string, opts ...notification.Opt) (*delivery, *bytes.Buffer) {
+	t.Helper()
+
+	var undelivered bytes.Buffer
+	d := newDelivery(notification.NewClient(url, opts...), deliveryConfig{
+		eventBufferSize: 100,
+		undelivered:     &undelivered,
+	}, discardLogger())
+
+	return d, &undelivered
+}
+
+// post posts the body to /messages and decodes
+// the response.
+func post(t *testing.T, h http.Handler, typ, body string) (*httptest.ResponseRecorder, response) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(body))
+	if typ != "" {
+		req.Header.Set("Content-Type", typ)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp response
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	return rec, resp
+}
@@ -0,0 +1,449 @@
+// Package input reads messages from a stream in
+// one of several formats.
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+// Format is the format of the input stream.
+type Format string
+
+const (
+	// FormatLine reads a message per line.
+	FormatLine Format = "line"
+
+	// FormatNDJSON reads a JSON object per line.
+	FormatNDJSON Format = "ndjson"
+
+	// FormatCSV reads a message per row, which is mapped
+	// to a JSON object using the header row as keys.
+	FormatCSV Format = "csv"
+
+	// FormatNUL reads messages terminated by a NUL byte.
+	FormatNUL Format = "nul"
+
+	// FormatDelimited reads messages terminated by
+	// a custom delimiter.
+	FormatDelimited Format = "delimited"
+
+	// FormatLengthPrefixed reads messages that are each
+	// preceded by their length as a 4 byte big endian
+	// unsigned integer.
+	FormatLengthPrefixed Format = "length-prefixed"
+
+	// FormatWhole reads the whole input as a
+	// single message.
+	FormatWhole Format = "whole"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatLine, FormatNDJSON, FormatCSV, FormatNUL, FormatDelimited, FormatLengthPrefixed, FormatWhole:
+		return f, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid input format %q, must be one of line, ndjson, csv, nul, delimited, length-prefixed or whole", s,
+		)
+	}
+}
+
+// structured reports whether fields can be selected
+// from records of the format.
+func (f Format) structured() bool {
+	return f == FormatNDJSON || f == FormatCSV
+}
+
+// Record is a message read from the input along with
+// the metadata selected from it.
+type Record struct {
+	// Message is the message to send.
+	Message notification.Message `json:"message"`
+
+	// ID is the identifier of the message.
+	ID string `json:"id,omitempty"`
+
+	// Key is the key of the message.
+	Key string `json:"key,omitempty"`
+
+	// Priority is the priority of the message.
+	Priority int `json:"priority,omitempty"`
+
+	// Truncated is set if the message was cut off
+	// at the max size.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Fields names the fields of structured records that
+// metadata is selected from.
+//
+// Fields that are empty are not selected.
+type Fields struct {
+	ID       string
+	Key      string
+	Priority string
+}
+
+func (f Fields) empty() bool {
+	return f == Fields{}
+}
+
+// Options configures a Reader.
+type Options struct {
+	// Format is the format of the input.
+	//
+	// It is FormatLine if empty.
+	Format Format
+
+	// Fields selects metadata from structured records.
+	Fields Fields
+
+	// Delimiter terminates messages with FormatDelimited
+	// and separates fields with FormatCSV, where it
+	// defaults to a comma.
+	Delimiter rune
+
+	// MaxSize is the max size of a message in bytes.
+	//
+	// It is 64KiB if zero.
+	MaxSize int
+
+	// Oversize determines what happens to messages that
+	// exceed MaxSize. Messages of structured formats can
+	// only be rejected.
+	//
+	// It is OversizeReject if empty.
+	Oversize OversizeMode
+}
+
+// InvalidRecordError is returned for a record that could
+// not be parsed.
+//
+// Reading can continue after it is returned.
+type InvalidRecordError struct {
+	// Raw is the record as read from the input.
+	Raw string
+
+	err error
+}
+
+// Error implements the error interface.
+func (e *InvalidRecordError) Error() string {
+	return fmt.Sprintf("invalid record: %s", e.err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidRecordError) Unwrap() error {
+	return e.err
+}
+
+// errTruncatedFrame is returned when the input ends in
+// the middle of a length prefixed message.
+var errTruncatedFrame = errors.New("input ends with a truncated message")
+
+// Validate returns an error if the options are invalid,
+// such as when fields are selected from unstructured
+// formats.
+func (o Options) Validate() error {
+	o = o.withDefaults()
+
+	if !o.Format.structured() && !o.Fields.empty() {
+		return fmt.Errorf("fields cannot be selected with the %s format", o.Format)
+	}
+	if o.Format.structured() && o.Oversize != OversizeReject {
+		return fmt.Errorf("messages of the %s format can only be rejected when too large", o.Format)
+	}
+	if o.Format == FormatDelimited && (o.Delimiter == 0 || o.Delimiter > 0x7f) {
+		return errors.New("the delimited format requires a single byte delimiter")
+	}
+
+	return nil
+}
+
+// withDefaults returns the options with defaults
+// for the fields that are not set.
+func (o Options) withDefaults() Options {
+	if o.Format == "" {
+		o.Format = FormatLine
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = bufio.MaxScanTokenSize
+	}
+	if o.Oversize == "" {
+		o.Oversize = OversizeReject
+	}
+
+	return o
+}
+
+// Reader reads records from an input stream.
+type Reader struct {
+	opts Options
+
+	// scanner splits the input for all formats but CSV.
+	scanner  *bufio.Scanner
+	splitter splitter
+
+	// csv reads the input with FormatCSV.
+	csv *csv.Reader
+
+	// header holds the keys of CSV rows.
+	header []string
+
+	// offset is the number of bytes of the input
+	// consumed by the records read so far.
+	offset int64
+}
+
+// NewReader returns a Reader that reads records from r.
+//
+// It returns an error if the options are invalid.
+func NewReader(r io.Reader, opts Options) (*Reader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	rd := &Reader{opts: opts}
+	if opts.Format == FormatCSV {
+		rd.csv = csv.NewReader(r)
+		if opts.Delimiter != 0 {
+			rd.csv.Comma = opts.Delimiter
+		}
+
+		return rd, nil
+	}
+
+	switch opts.Format {
+	case FormatNUL:
+		rd.splitter = rd.delimSplitter(0)
+	case FormatDelimited:
+		rd.splitter = rd.delimSplitter(byte(opts.Delimiter))
+	case FormatLengthPrefixed:
+		rd.splitter = &frameSplitter{max: opts.MaxSize, mode: opts.Oversize}
+	case FormatWhole:
+		s := rd.delimSplitter(0)
+		s.whole = true
+		rd.splitter = s
+	default:
+		s := rd.delimSplitter('\n')
+		s.dropCR = true
+		rd.splitter = s
+	}
+
+	// The buffer must hold a little more than the max
+	// size, so that oversized messages are detected and
+	// length prefixes fit.
+	limit := opts.MaxSize + 4
+	initial := 4096
+	if limit < initial {
+		initial = limit
+	}
+	rd.scanner = bufio.NewScanner(r)
+	rd.scanner.Buffer(make([]byte, 0, initial), limit)
+	rd.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, tok, err := rd.splitter.split(data, atEOF)
+		rd.offset += int64(advance)
+		return advance, tok, err
+	})
+
+	return rd, nil
+}
+
+func (r *Reader) delimSplitter(delim byte) *delimSplitter {
+	return &delimSplitter{
+		delim: delim,
+		max:   r.opts.MaxSize,
+		mode:  r.opts.Oversize,
+	}
+}
+
+// Offset returns the number of bytes of the input that
+// were consumed by the records read so far, which is the
+// offset in the input at which the last record ended.
+//
+// It is not tracked with FormatCSV and always zero.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Read returns the next record.
+//
+// It returns io.EOF once the input is exhausted, or an
+// InvalidRecordError for records that could not be parsed.
+// Any other error ends the input.
+func (r *Reader) Read() (Record, error) {
+	if r.csv != nil {
+		return r.readCSV()
+	}
+
+	for r.scanner.Scan() {
+		raw := r.scanner.Text()
+
+		oversized, truncated := r.splitter.last()
+		if oversized || truncated {
+			r.skip()
+		}
+		if oversized {
+			return Record{}, &InvalidRecordError{Raw: raw, err: ErrTooLarge}
+		}
+
+		if r.opts.Format != FormatNDJSON {
+			return Record{Message: raw, Truncated: truncated}, nil
+		}
+
+		// Skip blank lines between objects.
+		raw = string(bytes.TrimSpace(r.scanner.Bytes()))
+		if raw == "" {
+			continue
+		}
+
+		return r.readJSON(raw)
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+
+	return Record{}, io.EOF
+}
+
+// skip consumes the rest of an oversized message that
+// was cut off, so that the offset of its record is the
+// end of the message rather than the max size.
+//
+// A read error is returned by the next call to Read.
+func (r *Reader) skip() {
+	if r.splitter.skipping() {
+		r.scanner.Scan()
+	}
+}
+
+// readJSON parses a record with FormatNDJSON.
+func (r *Reader) readJSON(raw string) (Record, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return Record{}, &InvalidRecordError{Raw: raw, err: err}
+	}
+
+	// null decodes to a nil map without an error.
+	if obj == nil {
+		return Record{}, &InvalidRecordError{Raw: raw, err: errors.New("not a JSON object")}
+	}
+
+	rec := Record{Message: raw}
+	err := r.selectFields(&rec, func(name string) (string, bool) {
+		v, ok := obj[name]
+		if !ok {
+			return "", false
+		}
+
+		// Strings are unquoted; other values are
+		// used as they are.
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			return s, true
+		}
+
+		return string(v), true
+	})
+	if err != nil {
+		return Record{}, &InvalidRecordError{Raw: raw, err: err}
+	}
+
+	return rec, nil
+}
+
+// readCSV reads a record with FormatCSV.
+func (r *Reader) readCSV() (Record, error) {
+	if r.header == nil {
+		header, err := r.csv.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Record{}, io.EOF
+			}
+			return Record{}, fmt.Errorf("read csv header: %w", err)
+		}
+		r.header = header
+	}
+
+	row, err := r.csv.Read()
+	if err != nil {
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return Record{}, &InvalidRecordError{Raw: fmt.Sprint(row), err: err}
+		}
+		return Record{}, err
+	}
+
+	// Build the object by hand to keep the
+	// order of the header.
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range r.header {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		val, _ := json.Marshal(row[i])
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+
+	rec := Record{Message: buf.String()}
+	if len(rec.Message) > r.opts.MaxSize {
+		return Record{}, &InvalidRecordError{Raw: rec.Message[:r.opts.MaxSize], err: ErrTooLarge}
+	}
+
+	err = r.selectFields(&rec, func(name string) (string, bool) {
+		for i, k := range r.header {
+			if k == name {
+				return row[i], true
+			}
+		}
+		return "", false
+	})
+	if err != nil {
+		return Record{}, &InvalidRecordError{Raw: rec.Message, err: err}
+	}
+
+	return rec, nil
+}
+
+// selectFields sets the metadata of the record using
+// lookup to find the value of a field.
+//
+// Fields that are missing are left unset.
+func (r *Reader) selectFields(rec *Record, lookup func(name string) (string, bool)) error {
+	f := r.opts.Fields
+
+	if f.ID != "" {
+		rec.ID, _ = lookup(f.ID)
+	}
+	if f.Key != "" {
+		rec.Key, _ = lookup(f.Key)
+	}
+	if f.Priority != "" {
+		if v, ok := lookup(f.Priority); ok {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("priority field %q: %w", f.Priority, err)
+			}
+			rec.Priority = p
+		}
+	}
+
+	return nil
+}
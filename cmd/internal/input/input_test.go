@@ -0,0 +1,260 @@
+package input_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/input"
+)
+
+// readAll reads all records, collecting invalid ones
+// separately.
+func readAll(t *testing.T, r *input.Reader) ([]input.Record, []string) {
+	t.Helper()
+
+	var (
+		recs    []input.Record
+		invalid []string
+	)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs, invalid
+		}
+
+		var ire *input.InvalidRecordError
+		if errors.As(err, &ire) {
+			invalid = append(invalid, ire.Raw)
+			continue
+		}
+		if !assert.Nil(t, err) {
+			return recs, invalid
+		}
+
+		recs = append(recs, rec)
+	}
+}
+
+func TestReader_Line(t *testing.T) {
+	t.Parallel()
+
+	r, err := input.NewReader(strings.NewReader("a\nb\n"), input.Options{})
+	assert.Nil(t, err)
+
+	recs, _ := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "a"}, {Message: "b"}}, recs)
+}
+
+func TestReader_NDJSON(t *testing.T) {
+	t.Parallel()
+
+	in := `{"id":"1","key":"k","prio":5,"body":"a"}
+
+not json
+{"id":2,"prio":"x"}
+[1,2]
+null
+{"body":"b"}
+`
+	r, err := input.NewReader(strings.NewReader(in), input.Options{
+		Format: input.FormatNDJSON,
+		Fields: input.Fields{ID: "id", Key: "key", Priority: "prio"},
+	})
+	assert.Nil(t, err)
+
+	recs, invalid := readAll(t, r)
+	assert.Equal(t, []input.Record{
+		{Message: `{"id":"1","key":"k","prio":5,"body":"a"}`, ID: "1", Key: "k", Priority: 5},
+		{Message: `{"body":"b"}`},
+	}, recs)
+	assert.Equal(t, []string{"not json", `{"id":2,"prio":"x"}`, "[1,2]", "null"}, invalid)
+}
+
+func TestReader_CSV(t *testing.T) {
+	t.Parallel()
+
+	in := "id;body\n1;\"multi\nline\"\n2\n3;c\n"
+	r, err := input.NewReader(strings.NewReader(in), input.Options{
+		Format:    input.FormatCSV,
+		Fields:    input.Fields{ID: "id"},
+		Delimiter: ';',
+	})
+	assert.Nil(t, err)
+
+	recs, invalid := readAll(t, r)
+	assert.Equal(t, []input.Record{
+		{Message: `{"id":"1","body":"multi\nline"}`, ID: "1"},
+		{Message: `{"id":"3","body":"c"}`, ID: "3"},
+	}, recs)
+	assert.Len(t, invalid, 1)
+}
+
+func TestReader_Delimited(t *testing.T) {
+	t.Parallel()
+
+	r, err := input.NewReader(strings.NewReader("a\nb\x00c"), input.Options{Format: input.FormatNUL})
+	assert.Nil(t, err)
+
+	recs, _ := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "a\nb"}, {Message: "c"}}, recs)
+
+	r, err = input.NewReader(strings.NewReader("a|b|"), input.Options{
+		Format:    input.FormatDelimited,
+		Delimiter: '|',
+	})
+	assert.Nil(t, err)
+
+	recs, _ = readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "a"}, {Message: "b"}}, recs)
+
+	_, err = input.NewReader(strings.NewReader(""), input.Options{Format: input.FormatDelimited})
+	assert.Error(t, err)
+}
+
+func TestReader_Whole(t *testing.T) {
+	t.Parallel()
+
+	r, err := input.NewReader(strings.NewReader("a\nb\x00c\n"), input.Options{Format: input.FormatWhole})
+	assert.Nil(t, err)
+
+	recs, _ := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "a\nb\x00c\n"}}, recs)
+
+	r, err = input.NewReader(strings.NewReader("abcdefg"), input.Options{
+		Format:   input.FormatWhole,
+		MaxSize:  3,
+		Oversize: input.OversizeTruncate,
+	})
+	assert.Nil(t, err)
+
+	recs, _ = readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "abc", Truncated: true}}, recs)
+}
+
+func TestReader_LengthPrefixed(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	for _, msg := range []string{"hello\nworld", ""} {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+		buf.WriteString(msg)
+	}
+
+	r, err := input.NewReader(bytes.NewReader(buf.Bytes()), input.Options{Format: input.FormatLengthPrefixed})
+	assert.Nil(t, err)
+
+	recs, _ := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "hello\nworld"}, {Message: ""}}, recs)
+
+	// The last message is cut off.
+	r, err = input.NewReader(bytes.NewReader(buf.Bytes()[:8]), input.Options{Format: input.FormatLengthPrefixed})
+	assert.Nil(t, err)
+
+	_, err = r.Read()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestReader_Offset(t *testing.T) {
+	t.Parallel()
+
+	r, err := input.NewReader(strings.NewReader("ab\r\n\ncdefgh\nij"), input.Options{
+		MaxSize:  3,
+		Oversize: input.OversizeTruncate,
+	})
+	assert.Nil(t, err)
+
+	var offsets []int64
+	for {
+		_, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		offsets = append(offsets, r.Offset())
+	}
+
+	// The rest of the truncated message is consumed
+	// along with it.
+	assert.Equal(t, []int64{4, 5, 12, 14}, offsets)
+}
+
+func TestReader_Offset_Oversized(t *testing.T) {
+	t.Parallel()
+
+	var frames bytes.Buffer
+	for _, msg := range []string{"abcdefgh", "ij"} {
+		_ = binary.Write(&frames, binary.BigEndian, uint32(len(msg)))
+		frames.WriteString(msg)
+	}
+
+	tests := []struct {
+		name   string
+		in     string
+		format input.Format
+		mode   input.OversizeMode
+		next   string
+	}{
+		{name: "reject", in: "abcdefgh\nij\n", mode: input.OversizeReject, next: "ij"},
+		{name: "truncate", in: "abcdefgh\nij\n", mode: input.OversizeTruncate, next: "ij"},
+		{
+			name:   "length-prefixed",
+			in:     frames.String(),
+			format: input.FormatLengthPrefixed,
+			mode:   input.OversizeTruncate,
+			next:   "ij",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := input.Options{Format: tt.format, MaxSize: 3, Oversize: tt.mode}
+			r, err := input.NewReader(strings.NewReader(tt.in), opts)
+			assert.Nil(t, err)
+
+			// The offset of the oversized message is its end,
+			// as if it was checkpointed once it was handled.
+			_, _ = r.Read()
+			offset := r.Offset()
+
+			// Resuming from the offset reads the next
+			// message rather than the rest of the line.
+			r, err = input.NewReader(strings.NewReader(tt.in[offset:]), opts)
+			assert.Nil(t, err)
+
+			rec, err := r.Read()
+			assert.Nil(t, err)
+			assert.Equal(t, tt.next, rec.Message)
+		})
+	}
+}
+
+func TestNewReader_FieldsUnstructured(t *testing.T) {
+	t.Parallel()
+
+	_, err := input.NewReader(strings.NewReader(""), input.Options{
+		Format: input.FormatLine,
+		Fields: input.Fields{ID: "id"},
+	})
+	assert.Error(t, err)
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	f, err := input.ParseFormat("ndjson")
+	assert.Nil(t, err)
+	assert.Equal(t, input.FormatNDJSON, f)
+
+	_, err = input.ParseFormat("xml")
+	assert.Error(t, err)
+}
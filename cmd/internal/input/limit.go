@@ -0,0 +1,251 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrTooLarge is returned for messages that exceed the
+// max size with OversizeReject.
+var ErrTooLarge = errors.New("message exceeds max size")
+
+// OversizeMode determines what happens to messages that
+// exceed the max size.
+type OversizeMode string
+
+const (
+	// OversizeReject rejects messages that are too large.
+	OversizeReject OversizeMode = "reject"
+
+	// OversizeTruncate cuts messages off at the max size.
+	OversizeTruncate OversizeMode = "truncate"
+
+	// OversizeChunk splits messages into consecutive
+	// messages of at most the max size.
+	OversizeChunk OversizeMode = "chunk"
+)
+
+// ParseOversizeMode returns the mode with the given name.
+func ParseOversizeMode(s string) (OversizeMode, error) {
+	switch m := OversizeMode(s); m {
+	case OversizeReject, OversizeTruncate, OversizeChunk:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid oversize mode %q, must be one of reject, truncate or chunk", s)
+	}
+}
+
+// splitter splits the input into messages of at most max
+// bytes, handling larger messages according to mode.
+//
+// The flags describe the last message that was split.
+//
+// The rest of an oversized message that is rejected or
+// truncated is skipped by the next call to split, which
+// returns an empty token once it is done. skipping
+// reports whether there is a rest to skip.
+type splitter interface {
+	split(data []byte, atEOF bool) (int, []byte, error)
+	last() (oversized, truncated bool)
+	skipping() bool
+}
+
+// delimSplitter splits messages terminated by a delimiter.
+type delimSplitter struct {
+	delim  byte
+	dropCR bool
+	max    int
+	mode   OversizeMode
+
+	// whole is set if there is no delimiter, and
+	// the whole input is a single message.
+	whole bool
+
+	// discarding is set while the rest of an oversized
+	// message is skipped.
+	discarding bool
+
+	oversized bool
+	truncated bool
+}
+
+func (s *delimSplitter) last() (bool, bool) {
+	return s.oversized, s.truncated
+}
+
+func (s *delimSplitter) skipping() bool {
+	return s.discarding
+}
+
+func (s *delimSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	s.oversized, s.truncated = false, false
+
+	if s.discarding {
+		if i := s.index(data); i >= 0 {
+			s.discarding = false
+			return i + 1, data[:0], nil
+		}
+		if atEOF {
+			s.discarding = false
+			return len(data), data[:0], nil
+		}
+		return len(data), nil, nil
+	}
+
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	// A carriage return before the delimiter is part of
+	// the terminator, so it does not count towards the
+	// max size.
+	i := s.index(data)
+	end := i
+	if i < 0 {
+		end = len(data)
+	}
+	msg := s.trim(data[:end])
+
+	switch {
+	case i >= 0 && len(msg) <= s.max:
+		return i + 1, msg, nil
+	case i < 0 && len(msg) <= s.max:
+		// A last message that is not terminated
+		// is returned as well.
+		if atEOF {
+			return len(data), msg, nil
+		}
+		return 0, nil, nil
+	}
+
+	tok := data[:s.max]
+	switch s.mode {
+	case OversizeChunk:
+		return s.max, tok, nil
+	case OversizeTruncate:
+		s.truncated = true
+	default:
+		s.oversized = true
+	}
+	s.discarding = true
+
+	return s.max, tok, nil
+}
+
+// index returns the index of the first delimiter
+// in data, or -1 if there is none.
+func (s *delimSplitter) index(data []byte) int {
+	if s.whole {
+		return -1
+	}
+
+	return bytes.IndexByte(data, s.delim)
+}
+
+// trim drops a trailing carriage return from lines.
+func (s *delimSplitter) trim(tok []byte) []byte {
+	if s.dropCR && len(tok) > 0 && tok[len(tok)-1] == '\r' {
+		return tok[:len(tok)-1]
+	}
+
+	return tok
+}
+
+// frameSplitter splits messages that are each preceded
+// by their length as a 4 byte big endian unsigned integer.
+type frameSplitter struct {
+	max  int
+	mode OversizeMode
+
+	// left is the number of bytes of an oversized message
+	// that are yet to be chunked or skipped.
+	left int
+
+	// chunking is set if the bytes that are left are
+	// chunked rather than skipped.
+	chunking bool
+
+	oversized bool
+	truncated bool
+}
+
+func (s *frameSplitter) last() (bool, bool) {
+	return s.oversized, s.truncated
+}
+
+func (s *frameSplitter) skipping() bool {
+	return s.left > 0 && !s.chunking
+}
+
+func (s *frameSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	const prefix = 4
+
+	s.oversized, s.truncated = false, false
+
+	if s.left > 0 {
+		n := s.left
+		if s.chunking && n > s.max {
+			n = s.max
+		}
+		if !s.chunking && n > len(data) {
+			n = len(data)
+		}
+
+		if len(data) == 0 || len(data) < n {
+			if atEOF {
+				return 0, nil, errTruncatedFrame
+			}
+			return 0, nil, nil
+		}
+
+		s.left -= n
+		if !s.chunking {
+			if s.left == 0 {
+				return n, data[:0], nil
+			}
+			return n, nil, nil
+		}
+		return n, data[:n], nil
+	}
+
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if len(data) < prefix {
+		if atEOF {
+			return 0, nil, errTruncatedFrame
+		}
+		return 0, nil, nil
+	}
+
+	n := int(binary.BigEndian.Uint32(data))
+	size := n
+	if n > s.max {
+		if s.mode == OversizeChunk {
+			s.left, s.chunking = n, true
+			return prefix, nil, nil
+		}
+		size = s.max
+	}
+
+	if len(data)-prefix < size {
+		if atEOF {
+			return 0, nil, errTruncatedFrame
+		}
+		return 0, nil, nil
+	}
+
+	if n > s.max {
+		s.left, s.chunking = n-s.max, false
+		if s.mode == OversizeTruncate {
+			s.truncated = true
+		} else {
+			s.oversized = true
+		}
+	}
+
+	end := prefix + size
+	return end, data[prefix:end], nil
+}
@@ -0,0 +1,204 @@
+package input_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/input"
+)
+
+func TestReader_Oversize(t *testing.T) {
+	t.Parallel()
+
+	// A carriage return before the newline is not part
+	// of the message, so it does not make it oversized.
+	in := "abc\nabcdefgh\nab\r\nabcde\r\nabcdefghij\r\nabcdefghijklmnop"
+
+	tests := []struct {
+		mode    input.OversizeMode
+		recs    []input.Record
+		invalid []string
+	}{
+		{
+			mode: input.OversizeReject,
+			recs: []input.Record{
+				{Message: "abc"},
+				{Message: "ab"},
+				{Message: "abcde"},
+			},
+			invalid: []string{"abcde", "abcde", "abcde"},
+		},
+		{
+			mode: input.OversizeTruncate,
+			recs: []input.Record{
+				{Message: "abc"},
+				{Message: "abcde", Truncated: true},
+				{Message: "ab"},
+				{Message: "abcde"},
+				{Message: "abcde", Truncated: true},
+				{Message: "abcde", Truncated: true},
+			},
+		},
+		{
+			mode: input.OversizeChunk,
+			recs: []input.Record{
+				{Message: "abc"},
+				{Message: "abcde"},
+				{Message: "fgh"},
+				{Message: "ab"},
+				{Message: "abcde"},
+				{Message: "abcde"},
+				{Message: "fghij"},
+				{Message: "abcde"},
+				{Message: "fghij"},
+				{Message: "klmno"},
+				{Message: "p"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.mode), func(t *testing.T) {
+			t.Parallel()
+
+			r, err := input.NewReader(strings.NewReader(in), input.Options{
+				MaxSize:  5,
+				Oversize: tt.mode,
+			})
+			assert.Nil(t, err)
+
+			recs, invalid := readAll(t, r)
+			assert.Equal(t, tt.recs, recs)
+			assert.Equal(t, tt.invalid, invalid)
+		})
+	}
+}
+
+func TestReader_OversizeDefault(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 1<<20)
+
+	r, err := input.NewReader(strings.NewReader(long+"\nb\n"), input.Options{})
+	assert.Nil(t, err)
+
+	recs, invalid := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: "b"}}, recs)
+	assert.Len(t, invalid, 1)
+
+	r, err = input.NewReader(strings.NewReader(long+"\nb\n"), input.Options{MaxSize: 2 << 20})
+	assert.Nil(t, err)
+
+	recs, _ = readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: long}, {Message: "b"}}, recs)
+}
+
+func TestReader_OversizeLengthPrefixed(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	for _, msg := range []string{"abcdefgh", "ab", "abcdefghijk"} {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+		buf.WriteString(msg)
+	}
+
+	tests := []struct {
+		mode    input.OversizeMode
+		recs    []input.Record
+		invalid []string
+	}{
+		{
+			mode:    input.OversizeReject,
+			recs:    []input.Record{{Message: "ab"}},
+			invalid: []string{"abcde", "abcde"},
+		},
+		{
+			mode: input.OversizeTruncate,
+			recs: []input.Record{
+				{Message: "abcde", Truncated: true},
+				{Message: "ab"},
+				{Message: "abcde", Truncated: true},
+			},
+		},
+		{
+			mode: input.OversizeChunk,
+			recs: []input.Record{
+				{Message: "abcde"},
+				{Message: "fgh"},
+				{Message: "ab"},
+				{Message: "abcde"},
+				{Message: "fghij"},
+				{Message: "k"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.mode), func(t *testing.T) {
+			t.Parallel()
+
+			r, err := input.NewReader(bytes.NewReader(buf.Bytes()), input.Options{
+				Format:   input.FormatLengthPrefixed,
+				MaxSize:  5,
+				Oversize: tt.mode,
+			})
+			assert.Nil(t, err)
+
+			recs, invalid := readAll(t, r)
+			assert.Equal(t, tt.recs, recs)
+			assert.Equal(t, tt.invalid, invalid)
+		})
+	}
+
+	// An oversized message that is cut off.
+	r, err := input.NewReader(bytes.NewReader(buf.Bytes()[:10]), input.Options{
+		Format:   input.FormatLengthPrefixed,
+		MaxSize:  5,
+		Oversize: input.OversizeTruncate,
+	})
+	assert.Nil(t, err)
+
+	_, err = r.Read()
+	assert.Nil(t, err)
+	_, err = r.Read()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestReader_OversizeStructured(t *testing.T) {
+	t.Parallel()
+
+	_, err := input.NewReader(strings.NewReader(""), input.Options{
+		Format:   input.FormatNDJSON,
+		Oversize: input.OversizeChunk,
+	})
+	assert.Error(t, err)
+
+	r, err := input.NewReader(strings.NewReader("body\nabcdefgh\nab\n"), input.Options{
+		Format:  input.FormatCSV,
+		MaxSize: 14,
+	})
+	assert.Nil(t, err)
+
+	recs, invalid := readAll(t, r)
+	assert.Equal(t, []input.Record{{Message: `{"body":"ab"}`}}, recs)
+	assert.Equal(t, []string{`{"body":"abcde`}, invalid)
+}
+
+func TestParseOversizeMode(t *testing.T) {
+	t.Parallel()
+
+	m, err := input.ParseOversizeMode("chunk")
+	assert.Nil(t, err)
+	assert.Equal(t, input.OversizeChunk, m)
+
+	_, err = input.ParseOversizeMode("split")
+	assert.Error(t, err)
+}
@@ -0,0 +1,257 @@
+// Package listen receives messages from producers over
+// TCP, UDP or Unix domain sockets.
+package listen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDatagramSize is the size of the largest datagram
+// that can be received.
+const maxDatagramSize = 64 << 10
+
+// acceptRetryInterval is the time waited after an error
+// accepting a connection before accepting again.
+const acceptRetryInterval = 100 * time.Millisecond
+
+// Handler reads the messages of a producer from r.
+//
+// For stream networks, it is called for every connection
+// and r is the connection. For datagram networks, it is
+// called for every datagram and r holds the datagram.
+//
+// name describes the producer.
+type Handler func(name string, r io.Reader)
+
+// Listener receives messages on a network address.
+type Listener struct {
+	network string
+
+	// stream is set for stream networks.
+	stream net.Listener
+
+	// packet is set for datagram networks.
+	packet net.PacketConn
+}
+
+// Listen listens on the address, which is given as a URL
+// with the network as the scheme, such as tcp://:9000,
+// udp://127.0.0.1:9000 or unix:///run/notifier.sock.
+//
+// The supported networks are tcp, tcp4, tcp6, udp, udp4,
+// udp6, unix and unixgram. Unix sockets that were left
+// behind by a previous process are removed.
+func Listen(addr string) (*Listener, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok || address == "" {
+		return nil, fmt.Errorf("invalid listen address %q, must be of the form network://address", addr)
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		if network == "unix" {
+			if err := removeStale(address); err != nil {
+				return nil, err
+			}
+		}
+
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+
+		return &Listener{network: network, stream: ln}, nil
+	case "udp", "udp4", "udp6", "unixgram":
+		if network == "unixgram" {
+			if err := removeStale(address); err != nil {
+				return nil, err
+			}
+		}
+
+		pc, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+
+		return &Listener{network: network, packet: pc}, nil
+	default:
+		return nil, fmt.Errorf("unsupported network %q in listen address %q", network, addr)
+	}
+}
+
+// removeStale removes a socket file at path.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat socket: %w", err)
+	}
+
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	return nil
+}
+
+// Addr returns the address the listener listens on.
+func (l *Listener) Addr() net.Addr {
+	if l.stream != nil {
+		return l.stream.Addr()
+	}
+
+	return l.packet.LocalAddr()
+}
+
+// Serve calls h for every connection or datagram that is
+// received, until ctx is done.
+//
+// Connections are handled concurrently, each in its own go
+// routine, so that a slow handler only holds up its own
+// producer. Datagrams are handled one at a time.
+//
+// Once ctx is done, the listener and all connections are
+// closed, and Serve returns once all handlers returned.
+func (l *Listener) Serve(ctx context.Context, h Handler) error {
+	if l.stream != nil {
+		return l.serveStream(ctx, h)
+	}
+
+	return l.servePackets(ctx, h)
+}
+
+func (l *Listener) serveStream(ctx context.Context, h Handler) error {
+	var (
+		wg    sync.WaitGroup
+		m     sync.Mutex
+		conns = make(map[net.Conn]struct{})
+	)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+
+		_ = l.stream.Close()
+
+		m.Lock()
+		defer m.Unlock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	defer wg.Wait()
+
+	for {
+		conn, err := l.stream.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			// Errors such as running out of file
+			// descriptors may resolve themselves.
+			select {
+			case <-time.After(acceptRetryInterval):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		m.Lock()
+		if ctx.Err() != nil {
+			m.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		conns[conn] = struct{}{}
+		m.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				m.Lock()
+				delete(conns, conn)
+				m.Unlock()
+
+				_ = conn.Close()
+			}()
+
+			h(producer(l.network, conn.RemoteAddr()), conn)
+		}()
+	}
+}
+
+func (l *Listener) servePackets(ctx context.Context, h Handler) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = l.packet.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := l.packet.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receive: %w", err)
+		}
+
+		h(producer(l.network, from), bytes.NewReader(buf[:n]))
+	}
+}
+
+// producer returns a name for the producer at addr.
+//
+// Producers on Unix sockets are usually unnamed.
+func producer(network string, addr net.Addr) string {
+	if addr == nil || addr.String() == "" {
+		return network + " producer"
+	}
+
+	return network + " " + addr.String()
+}
+
+// Close closes the listener.
+//
+// It only needs to be called if Serve is not.
+func (l *Listener) Close() error {
+	if l.stream != nil {
+		return l.stream.Close()
+	}
+
+	return l.packet.Close()
+}
@@ -0,0 +1,163 @@
+package listen_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/listen"
+)
+
+// collector collects the lines that are received.
+type collector struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (c *collector) handle(_ string, r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		c.m.Lock()
+		c.lines = append(c.lines, s.Text())
+		c.m.Unlock()
+	}
+}
+
+func (c *collector) sorted() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	lines := append([]string(nil), c.lines...)
+	sort.Strings(lines)
+	return lines
+}
+
+// serve serves the listener until the returned
+// function is called.
+func serve(t *testing.T, l *listen.Listener, h listen.Handler) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Serve(ctx, h)
+	}()
+
+	return func() {
+		cancel()
+		assert.Nil(t, <-done)
+	}
+}
+
+func TestListener_TCP(t *testing.T) {
+	t.Parallel()
+
+	l, err := listen.Listen("tcp://127.0.0.1:0")
+	assert.Nil(t, err)
+
+	var c collector
+	stop := serve(t, l, c.handle)
+
+	// A connection that is held open does not
+	// hold up the others.
+	idle, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer idle.Close()
+
+	for _, msg := range []string{"a\nb\n", "c\n"} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		_, err = conn.Write([]byte(msg))
+		assert.Nil(t, err)
+		assert.Nil(t, conn.Close())
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(c.sorted()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, c.sorted())
+
+	// Open connections are closed when stopping.
+	stop()
+}
+
+func TestListener_UDP(t *testing.T) {
+	t.Parallel()
+
+	l, err := listen.Listen("udp://127.0.0.1:0")
+	assert.Nil(t, err)
+
+	var c collector
+	stop := serve(t, l, c.handle)
+	defer stop()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("a\nb"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(c.sorted()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "b"}, c.sorted())
+}
+
+func TestListener_Unix(t *testing.T) {
+	t.Parallel()
+
+	// Socket paths are limited in length.
+	dir, err := os.MkdirTemp("", "listen")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "n.sock")
+
+	// A socket left behind is replaced.
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.Nil(t, stale.Close())
+
+	l, err := listen.Listen("unix://" + path)
+	assert.Nil(t, err)
+
+	var c collector
+	stop := serve(t, l, c.handle)
+	defer stop()
+
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("a\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		return len(c.sorted()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Files that are not sockets are kept.
+	file := filepath.Join(dir, "file")
+	assert.Nil(t, os.WriteFile(file, nil, 0o644))
+	_, err = listen.Listen("unix://" + file)
+	assert.Error(t, err)
+}
+
+func TestListen_InvalidAddress(t *testing.T) {
+	t.Parallel()
+
+	for _, addr := range []string{":9000", "tcp://", "http://:9000"} {
+		_, err := listen.Listen(addr)
+		assert.Error(t, err, addr)
+	}
+}
@@ -0,0 +1,264 @@
+// Package spool implements a file backed queue of values
+// that could not be handled right away.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// InvalidValuesError is returned by Replay for values
+// that could not be decoded.
+//
+// The values are removed from the spool, and the others
+// are replayed regardless.
+type InvalidValuesError struct {
+	// Raw are the values as read from the spool.
+	Raw []string
+
+	err error
+}
+
+// Error implements the error interface.
+func (e *InvalidValuesError) Error() string {
+	return fmt.Sprintf("skipped %d invalid values: %s", len(e.Raw), e.err.Error())
+}
+
+// Unwrap returns the error decoding the first value.
+func (e *InvalidValuesError) Unwrap() error {
+	return e.err
+}
+
+// Spool holds values in a file until they are replayed.
+//
+// Values are encoded as JSON, one per line, so that
+// values spanning multiple lines are kept intact.
+//
+// Values that are not replayed by the time the spool
+// is closed remain in the file, and are replayed when
+// it is opened again.
+//
+// It is safe for concurrent use.
+type Spool[T any] struct {
+	f *os.File
+
+	// off is the offset of the first value
+	// that was not replayed yet.
+	off int64
+
+	// n is the number of values that were
+	// not replayed yet.
+	n int
+
+	m sync.Mutex
+}
+
+// Open opens the spool file at path, creating it
+// if it does not exist.
+//
+// A last value that was only partly written, such as
+// when the process crashed while writing it, is cut off.
+func Open[T any](path string) (*Spool[T], error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open spool: %w", err)
+	}
+
+	n, end, size, err := countLines(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("read spool: %w", err)
+	}
+
+	// Values written after a partial one would be
+	// appended to it, and could never be decoded.
+	if end < size {
+		if err := f.Truncate(end); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("truncate spool: %w", err)
+		}
+	}
+
+	return &Spool[T]{f: f, n: n}, nil
+}
+
+// Write appends values to the spool.
+func (s *Spool[T]) Write(vs ...T) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// The encoder terminates every value with a newline.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("encode value: %w", err)
+		}
+	}
+
+	if _, err := s.f.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek spool: %w", err)
+	}
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write spool: %w", err)
+	}
+	s.n += len(vs)
+
+	return nil
+}
+
+// Len returns the number of values in the spool.
+func (s *Spool[T]) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.n
+}
+
+// Replay calls fn with every spooled value in the
+// order they were written.
+//
+// It stops at the first value fn returns an error for,
+// which is kept in the spool to be replayed again later.
+// It returns the number of values replayed.
+//
+// Values that cannot be decoded are removed, so that they
+// do not hold back the ones after them, and returned in an
+// *InvalidValuesError.
+func (s *Spool[T]) Replay(fn func(v T) error) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.n == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.f.Seek(s.off, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek spool: %w", err)
+	}
+
+	var (
+		replayed int
+		invalid  *InvalidValuesError
+	)
+	r := bufio.NewReader(s.f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return replayed, fmt.Errorf("read spool: %w", err)
+		}
+
+		var v T
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			if invalid == nil {
+				invalid = &InvalidValuesError{err: fmt.Errorf("decode value: %w", err)}
+			}
+			invalid.Raw = append(invalid.Raw, strings.TrimSuffix(line, "\n"))
+
+			s.off += int64(len(line))
+			s.n--
+			continue
+		}
+
+		if fn(v) != nil {
+			break
+		}
+		s.off += int64(len(line))
+		s.n--
+		replayed++
+	}
+
+	// Reclaim the space once everything was replayed.
+	if s.n == 0 {
+		if err := s.f.Truncate(0); err != nil {
+			return replayed, fmt.Errorf("truncate spool: %w", err)
+		}
+		s.off = 0
+	}
+
+	if invalid != nil {
+		return replayed, invalid
+	}
+
+	return replayed, nil
+}
+
+// Close compacts the spool, so that only values that
+// were not replayed remain, and closes the file.
+func (s *Spool[T]) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.compact(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+
+	return s.f.Close()
+}
+
+// compact moves the values that were not replayed
+// to the start of the file.
+//
+// It must be called with the lock held.
+func (s *Spool[T]) compact() error {
+	if s.off == 0 {
+		return nil
+	}
+
+	if _, err := s.f.Seek(s.off, io.SeekStart); err != nil {
+		return fmt.Errorf("seek spool: %w", err)
+	}
+	rest, err := io.ReadAll(s.f)
+	if err != nil {
+		return fmt.Errorf("read spool: %w", err)
+	}
+
+	if err := s.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+	if _, err := s.f.WriteAt(rest, 0); err != nil {
+		return fmt.Errorf("write spool: %w", err)
+	}
+	s.off = 0
+
+	return nil
+}
+
+// countLines returns the number of lines in r that are
+// terminated by a newline, the offset at which the last
+// of them ends and the size of r.
+func countLines(r io.Reader) (int, int64, int64, error) {
+	var (
+		n         int
+		end, size int64
+	)
+
+	buf := make([]byte, 32<<10)
+	for {
+		k, err := r.Read(buf)
+
+		chunk := buf[:k]
+		n += bytes.Count(chunk, []byte{'\n'})
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = size + int64(i) + 1
+		}
+		size += int64(k)
+
+		if err == io.EOF {
+			return n, end, size, nil
+		}
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package spool_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/spool"
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+func TestSpool_Replay(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Write("a", "b"))
+	assert.Nil(t, s.Write("c"))
+	assert.Equal(t, 3, s.Len())
+
+	var got []notification.Message
+	n, err := s.Replay(func(msg notification.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []notification.Message{"a", "b", "c"}, got)
+	assert.Equal(t, 0, s.Len())
+
+	assert.Nil(t, s.Close())
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestSpool_Replay_Partial(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Write("a", "b", "c"))
+
+	// Stop at the second message.
+	n, err := s.Replay(func(msg notification.Message) error {
+		if msg == "b" {
+			return errors.New("full")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, s.Len())
+
+	assert.Nil(t, s.Write("d"))
+	assert.Nil(t, s.Close())
+
+	// What was left is replayed after reopening.
+	s, err = spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.Len())
+
+	var got []notification.Message
+	_, err = s.Replay(func(msg notification.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []notification.Message{"b", "c", "d"}, got)
+	assert.Nil(t, s.Close())
+}
+
+func TestSpool_Replay_Invalid(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	assert.Nil(t, os.WriteFile(path, []byte("\"a\"\n{\n\"b\"\n"), 0o644))
+
+	s, err := spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.Len())
+
+	// The invalid value is removed rather than
+	// holding back the ones after it.
+	var got []notification.Message
+	n, err := s.Replay(func(msg notification.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	var ive *spool.InvalidValuesError
+	assert.True(t, errors.As(err, &ive))
+	assert.Equal(t, []string{"{"}, ive.Raw)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []notification.Message{"a", "b"}, got)
+	assert.Equal(t, 0, s.Len())
+
+	n, err = s.Replay(func(msg notification.Message) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, s.Close())
+}
+
+func TestSpool_MultiLine(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		Message notification.Message
+		ID      string
+	}
+
+	s, err := spool.Open[record](filepath.Join(t.TempDir(), "spool"))
+	assert.Nil(t, err)
+
+	want := []record{{Message: "a\nb", ID: "1"}, {Message: "c"}}
+	assert.Nil(t, s.Write(want...))
+	assert.Equal(t, 2, s.Len())
+
+	var got []record
+	_, err = s.Replay(func(r record) error {
+		got = append(got, r)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+	assert.Nil(t, s.Close())
+}
+
+func TestSpool_Open_PartialValue(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write("a", "b"))
+	assert.Nil(t, s.Close())
+
+	// A crash while writing a value.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`"partial`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Nil(t, s.Write("c"))
+
+	var got []notification.Message
+	n, err := s.Replay(func(msg notification.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []notification.Message{"a", "b", "c"}, got)
+	assert.Nil(t, s.Close())
+}
+
+func TestSpool_Open_LongValue(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+
+	long := notification.Message(strings.Repeat("a", 1<<20))
+	assert.Nil(t, s.Write(long, "b"))
+	assert.Nil(t, s.Close())
+
+	s, err = spool.Open[notification.Message](path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Nil(t, s.Close())
+}
@@ -0,0 +1,273 @@
+// Package spooldir picks up files dropped into a directory
+// and moves them aside once their messages were handled.
+package spooldir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DoneDir is the subdirectory that files whose
+	// messages were all delivered are moved to.
+	DoneDir = "done"
+
+	// FailedDir is the subdirectory that files with
+	// undelivered messages are moved to.
+	FailedDir = "failed"
+)
+
+// ErrDuplicateID is returned when tracking a message whose
+// ID is shared by a message that was not settled yet.
+var ErrDuplicateID = errors.New("message id is already pending")
+
+// file is a file whose messages are being delivered.
+type file struct {
+	// pending is the number of messages that
+	// were not settled yet.
+	pending int
+
+	// failed is set if a message was not delivered
+	// or the file could not be read.
+	failed bool
+
+	// sealed is set once all messages of
+	// the file were read.
+	sealed bool
+}
+
+// Dir is a spool directory that files are dropped into.
+//
+// Files are moved to the DoneDir subdirectory once all of
+// their messages were delivered, or to the FailedDir
+// subdirectory if any of them was not.
+//
+// Files are expected to appear atomically, by writing them
+// under a temporary name and renaming them once they are
+// complete. Hidden files, whose names start with a dot, and
+// files ending in .tmp are considered temporary and ignored.
+//
+// It is safe for concurrent use.
+type Dir struct {
+	path string
+
+	// files holds the files whose messages
+	// are being delivered by their path.
+	files map[string]*file
+
+	// ids holds the files of messages that were not
+	// settled yet by the ID of the message.
+	ids map[string]string
+
+	m sync.Mutex
+}
+
+// Open opens the spool directory at path, creating
+// it and its subdirectories if they do not exist.
+func Open(path string) (*Dir, error) {
+	for _, dir := range []string{path, filepath.Join(path, DoneDir), filepath.Join(path, FailedDir)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create spool directory: %w", err)
+		}
+	}
+
+	return &Dir{
+		path:  path,
+		files: make(map[string]*file),
+		ids:   make(map[string]string),
+	}, nil
+}
+
+// Watch calls fn with the path of every file that appears
+// in the directory, including the files that are already
+// in it, checking every poll interval until ctx is done.
+//
+// fn is called once per file, in the order of their names,
+// and is not called again until it returns.
+func (d *Dir) Watch(ctx context.Context, poll time.Duration, fn func(path string)) error {
+	seen := make(map[string]bool)
+	for {
+		names, err := d.list()
+		if err != nil {
+			return err
+		}
+
+		// Names are forgotten once the files were moved,
+		// so that files with the same name are picked up.
+		present := make(map[string]bool, len(names))
+		for _, name := range names {
+			present[name] = true
+		}
+		for name := range seen {
+			if !present[name] {
+				delete(seen, name)
+			}
+		}
+
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			if ctx.Err() != nil {
+				return nil
+			}
+			fn(filepath.Join(d.path, name))
+		}
+
+		timer := time.NewTimer(poll)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// list returns the names of the files in the
+// directory that are not temporary.
+func (d *Dir) list() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Track records that the message with the given ID
+// was read from the file at path.
+//
+// If a message that was not settled yet has the same ID,
+// ErrDuplicateID is returned and the file is marked as
+// failed, since the message cannot be settled.
+func (d *Dir) Track(id, path string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	f, ok := d.files[path]
+	if !ok {
+		f = &file{}
+		d.files[path] = f
+	}
+
+	if _, ok := d.ids[id]; ok {
+		f.failed = true
+		return ErrDuplicateID
+	}
+	f.pending++
+	d.ids[id] = path
+
+	return nil
+}
+
+// Seal records that all messages of the file at path were
+// read, and whether it was read without error.
+//
+// The file is moved once its messages are settled, which
+// may be right away.
+func (d *Dir) Seal(path string, ok bool) error {
+	d.m.Lock()
+	f, found := d.files[path]
+	if !found {
+		f = &file{}
+		d.files[path] = f
+	}
+	f.sealed = true
+	f.failed = f.failed || !ok
+	complete := d.complete(path, f)
+	d.m.Unlock()
+
+	if !complete {
+		return nil
+	}
+	return d.move(path, f.failed)
+}
+
+// Settle records whether the message with the given ID
+// was delivered.
+//
+// IDs of messages that were not tracked, or were
+// settled already, are ignored.
+func (d *Dir) Settle(id string, delivered bool) error {
+	d.m.Lock()
+	path, ok := d.ids[id]
+	if !ok {
+		d.m.Unlock()
+		return nil
+	}
+	delete(d.ids, id)
+
+	f := d.files[path]
+	f.pending--
+	f.failed = f.failed || !delivered
+	complete := d.complete(path, f)
+	d.m.Unlock()
+
+	if !complete {
+		return nil
+	}
+	return d.move(path, f.failed)
+}
+
+// complete forgets the file at path if all of its messages
+// were read and settled, and returns whether they were.
+//
+// It must be called with the lock held.
+func (d *Dir) complete(path string, f *file) bool {
+	if !f.sealed || f.pending > 0 {
+		return false
+	}
+	delete(d.files, path)
+
+	return true
+}
+
+// move moves the file at path into the subdirectory
+// for its outcome.
+func (d *Dir) move(path string, failed bool) error {
+	sub := DoneDir
+	if failed {
+		sub = FailedDir
+	}
+
+	return moveInto(path, filepath.Join(d.path, sub))
+}
+
+// moveInto moves the file at path into dir.
+//
+// If dir holds a file of the same name, the name is
+// suffixed with the time, so that it is not replaced.
+func moveInto(path, dir string) error {
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Lstat(target); !errors.Is(err, fs.ErrNotExist) {
+		target = fmt.Sprintf("%s.%d", target, time.Now().UnixNano())
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("move %s: %w", path, err)
+	}
+
+	return nil
+}
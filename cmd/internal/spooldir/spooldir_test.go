@@ -0,0 +1,118 @@
+package spooldir_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/spooldir"
+)
+
+func TestDir_Watch(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	d, err := spooldir.Open(path)
+	assert.Nil(t, err)
+
+	for _, name := range []string{"b", "a", ".hidden", "c.tmp"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(path, name), nil, 0o644))
+	}
+
+	var (
+		m     sync.Mutex
+		names []string
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- d.Watch(ctx, 10*time.Millisecond, func(p string) {
+			m.Lock()
+			defer m.Unlock()
+			names = append(names, filepath.Base(p))
+		})
+	}()
+
+	count := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return len(names)
+	}
+	assert.Eventually(t, func() bool { return count() == 2 }, time.Second, 10*time.Millisecond)
+
+	// Renamed into place once written, and a file
+	// of the same name after the first was moved.
+	assert.Nil(t, os.Rename(filepath.Join(path, "c.tmp"), filepath.Join(path, "c")))
+	assert.Nil(t, os.Remove(filepath.Join(path, "a")))
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, os.WriteFile(filepath.Join(path, "a"), nil, 0o644))
+	assert.Eventually(t, func() bool { return count() == 4 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"a", "b", "c", "a"}, names)
+}
+
+func TestDir_Settle(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	d, err := spooldir.Open(path)
+	assert.Nil(t, err)
+
+	write := func(name string) string {
+		p := filepath.Join(path, name)
+		assert.Nil(t, os.WriteFile(p, []byte(name), 0o644))
+		return p
+	}
+	exists := func(elem ...string) bool {
+		_, err := os.Stat(filepath.Join(append([]string{path}, elem...)...))
+		return err == nil
+	}
+
+	ok, bad, dup := write("ok"), write("bad"), write("dup")
+	empty, unread := write("empty"), write("unread")
+
+	assert.Nil(t, d.Track("1", ok))
+	assert.Nil(t, d.Track("2", ok))
+	assert.Nil(t, d.Track("3", bad))
+	assert.Nil(t, d.Track("4", dup))
+
+	// A message whose ID is still pending cannot
+	// be settled, so its file fails.
+	assert.ErrorIs(t, d.Track("1", dup), spooldir.ErrDuplicateID)
+
+	assert.Nil(t, d.Settle("1", true))
+	assert.Nil(t, d.Seal(ok, true))
+	assert.True(t, exists("ok"))
+	assert.Nil(t, d.Settle("2", true))
+	assert.True(t, exists(spooldir.DoneDir, "ok"))
+	assert.False(t, exists("ok"))
+
+	assert.Nil(t, d.Seal(bad, true))
+	assert.Nil(t, d.Settle("3", false))
+	assert.True(t, exists(spooldir.FailedDir, "bad"))
+
+	assert.Nil(t, d.Seal(dup, true))
+	assert.Nil(t, d.Settle("4", true))
+	assert.Nil(t, d.Settle("1", true))
+	assert.True(t, exists(spooldir.FailedDir, "dup"))
+
+	assert.Nil(t, d.Seal(empty, true))
+	assert.True(t, exists(spooldir.DoneDir, "empty"))
+
+	assert.Nil(t, d.Seal(unread, false))
+	assert.True(t, exists(spooldir.FailedDir, "unread"))
+
+	// Files of the same name are not replaced.
+	ok = write("ok")
+	assert.Nil(t, d.Seal(ok, true))
+	entries, err := os.ReadDir(filepath.Join(path, spooldir.DoneDir))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+}
@@ -0,0 +1,334 @@
+// Package tail reads files as they are written to,
+// following them across truncation and rotation.
+package tail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResumeFunc is called whenever a File opens the file at
+// its path. It returns a key that identifies the file and
+// the offset from which the file is to be read.
+type ResumeFunc func(f *os.File) (key string, offset int64)
+
+// Opt configures a File.
+type Opt func(t *File)
+
+// WithResume sets the function that determines where
+// files are read from when they are opened.
+//
+// By default, files are read from the start.
+func WithResume(fn ResumeFunc) Opt {
+	return func(t *File) {
+		t.resume = fn
+	}
+}
+
+// segment is a part of what was read from a File that
+// was read from a single file in one go.
+type segment struct {
+	// pos is the position at which the segment starts
+	// in what was read from the File.
+	pos int64
+
+	// key identifies the file the segment was read from.
+	key string
+
+	// offset is the offset in the file at which
+	// the segment starts.
+	offset int64
+}
+
+// File reads a file as it is written to, like tail -F.
+//
+// Once the end of the file is reached, Read waits for more
+// to be written instead of returning io.EOF. If the file is
+// truncated, it is read from the start again. If it is
+// rotated, which is detected by comparing the identity of
+// the file at the path with the one being read, the file
+// that took its place is read from the start. A file that
+// does not exist yet is waited for.
+//
+// Read returns io.EOF once the context is done.
+//
+// It is not safe for concurrent use.
+type File struct {
+	ctx  context.Context
+	path string
+
+	// poll is the interval at which the file is
+	// checked for changes at its end.
+	poll time.Duration
+
+	// f is the file being read.
+	//
+	// It is nil until the file exists.
+	f *os.File
+
+	// info identifies f.
+	info os.FileInfo
+
+	// offset is the offset in f up to which
+	// it was read.
+	offset int64
+
+	// pos is the number of bytes read from the File.
+	pos int64
+
+	resume ResumeFunc
+
+	// segments map positions to the files they
+	// were read from, in order.
+	segments []segment
+}
+
+// Follow returns a File that follows the file at path
+// until ctx is done.
+//
+// Note that Close must be called to release resources.
+func Follow(ctx context.Context, path string, poll time.Duration, opts ...Opt) *File {
+	t := &File{
+		ctx:  ctx,
+		path: path,
+		poll: poll,
+		resume: func(*os.File) (string, int64) {
+			return "", 0
+		},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Read reads from the file, waiting for data to be
+// written if the end of the file was reached.
+func (t *File) Read(p []byte) (int, error) {
+	for {
+		if t.f != nil {
+			n, err := t.f.Read(p)
+			t.offset += int64(n)
+			t.pos += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, fmt.Errorf("read %s: %w", t.path, err)
+			}
+		}
+
+		// Only once the end of the file is reached can
+		// it be told whether it was rotated or truncated.
+		changed, err := t.check()
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			continue
+		}
+
+		timer := time.NewTimer(t.poll)
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			timer.Stop()
+			return 0, io.EOF
+		}
+	}
+}
+
+// check reopens the file if it was rotated, or seeks to
+// its start if it was truncated.
+//
+// It returns whether the file changed.
+func (t *File) check() (bool, error) {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The file is either yet to be created, or
+		// was moved and is yet to be replaced, in
+		// which case it may still be written to.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", t.path, err)
+	}
+
+	if t.f == nil || !os.SameFile(info, t.info) {
+		return t.open()
+	}
+
+	if info.Size() < t.offset {
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return false, fmt.Errorf("seek %s: %w", t.path, err)
+		}
+		t.offset = 0
+		t.segments = append(t.segments, segment{
+			pos: t.pos,
+			key: t.segments[len(t.segments)-1].key,
+		})
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// open opens the file at the path in place of
+// the one that is being read.
+func (t *File) open() (bool, error) {
+	f, err := os.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", t.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return false, fmt.Errorf("stat %s: %w", t.path, err)
+	}
+
+	// Offsets beyond the end are not resumed from,
+	// since the file must have been truncated.
+	key, offset := t.resume(f)
+	if offset > info.Size() {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return false, fmt.Errorf("seek %s: %w", t.path, err)
+	}
+
+	if t.f != nil {
+		_ = t.f.Close()
+	}
+	t.f, t.info, t.offset = f, info, offset
+	t.segments = append(t.segments, segment{pos: t.pos, key: key, offset: offset})
+
+	return true, nil
+}
+
+// Locate returns the key of the file that the byte before
+// position pos of what was read from the File was read
+// from, along with the offset in the file that follows it.
+//
+// Positions must be located in increasing order, since
+// what is needed to locate earlier positions is discarded.
+func (t *File) Locate(pos int64) (key string, offset int64) {
+	// A position at the start of a segment ends the
+	// previous one, unless there is none.
+	i := 0
+	for i+1 < len(t.segments) && t.segments[i+1].pos < pos {
+		i++
+	}
+	t.segments = t.segments[i:]
+
+	if len(t.segments) == 0 {
+		return "", 0
+	}
+	seg := t.segments[0]
+
+	return seg.key, seg.offset + pos - seg.pos
+}
+
+// Close closes the file.
+func (t *File) Close() error {
+	if t.f == nil {
+		return nil
+	}
+
+	return t.f.Close()
+}
+
+// Glob returns the files that match the patterns, in
+// lexical order and without duplicates.
+//
+// It returns an error if a pattern is malformed or
+// does not match any file.
+func Glob(patterns []string) ([]string, error) {
+	var paths []string
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", p)
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	return dedup(paths), nil
+}
+
+// Watch calls fn once for every file that matches one of
+// the patterns, both for files that exist when it is called
+// and files that are created later on, until ctx is done.
+//
+// Patterns without wildcards always match, since the file
+// may be created later.
+func Watch(ctx context.Context, patterns []string, poll time.Duration, fn func(path string)) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for {
+		var paths []string
+		for _, p := range patterns {
+			if !strings.ContainsAny(p, "*?[") {
+				paths = append(paths, p)
+				continue
+			}
+
+			// The pattern was validated, so there
+			// is no error to handle.
+			matches, _ := filepath.Glob(p)
+			paths = append(paths, matches...)
+		}
+
+		for _, path := range dedup(paths) {
+			if !seen[path] {
+				seen[path] = true
+				fn(path)
+			}
+		}
+
+		timer := time.NewTimer(poll)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// dedup sorts the paths and removes duplicates.
+func dedup(paths []string) []string {
+	sort.Strings(paths)
+
+	out := paths[:0]
+	for i, p := range paths {
+		if i == 0 || p != paths[i-1] {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
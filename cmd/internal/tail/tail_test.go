@@ -0,0 +1,198 @@
+package tail_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/cmd/internal/tail"
+)
+
+const poll = 10 * time.Millisecond
+
+// lines reads lines from the file on a channel.
+func lines(t *testing.T, f *tail.File) <-chan string {
+	t.Helper()
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			ch <- s.Text()
+		}
+		assert.Nil(t, s.Err())
+	}()
+
+	return ch
+}
+
+// next returns the next line or fails if there is none.
+func next(t *testing.T, ch <-chan string) string {
+	t.Helper()
+
+	select {
+	case l := <-ch:
+		return l
+	case <-time.After(time.Second):
+		assert.Fail(t, "expected a line")
+		return ""
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(data)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func TestFile_Follow(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := tail.Follow(ctx, path, poll)
+	defer f.Close()
+
+	ch := lines(t, f)
+
+	// The file is waited for.
+	appendFile(t, path, "a\n")
+	assert.Equal(t, "a", next(t, ch))
+
+	appendFile(t, path, "b\n")
+	assert.Equal(t, "b", next(t, ch))
+
+	// Truncated.
+	assert.Nil(t, os.Truncate(path, 0))
+	<-time.After(5 * poll)
+	appendFile(t, path, "c\n")
+	assert.Equal(t, "c", next(t, ch))
+
+	// Rotated.
+	assert.Nil(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", "d\n")
+	assert.Equal(t, "d", next(t, ch))
+	appendFile(t, path, "e\n")
+	assert.Equal(t, "e", next(t, ch))
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestFile_Resume(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "a\nb\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var opened int
+	f := tail.Follow(ctx, path, poll, tail.WithResume(func(*os.File) (string, int64) {
+		opened++
+		// The second offset is beyond the end of the
+		// file that takes the place of the first.
+		return fmt.Sprint(opened), int64(opened * 2)
+	}))
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "b\n", line)
+
+	key, offset := f.Locate(2)
+	assert.Equal(t, "1", key)
+	assert.Equal(t, int64(4), offset)
+
+	assert.Nil(t, os.Rename(path, path+".1"))
+	appendFile(t, path, "c\n")
+
+	line, err = r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "c\n", line)
+
+	key, offset = f.Locate(4)
+	assert.Equal(t, "2", key)
+	assert.Equal(t, int64(2), offset)
+}
+
+func TestGlob(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"b.log", "a.log", "c.txt"} {
+		appendFile(t, filepath.Join(dir, name), "")
+	}
+
+	paths, err := tail.Glob([]string{
+		filepath.Join(dir, "*.log"),
+		filepath.Join(dir, "a.log"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a.log"),
+		filepath.Join(dir, "b.log"),
+	}, paths)
+
+	_, err = tail.Glob([]string{filepath.Join(dir, "*.json")})
+	assert.Error(t, err)
+
+	_, err = tail.Glob([]string{"["})
+	assert.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	appendFile(t, filepath.Join(dir, "a.log"), "")
+
+	var (
+		m     sync.Mutex
+		paths []string
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- tail.Watch(ctx, []string{
+			filepath.Join(dir, "*.log"),
+			filepath.Join(dir, "later.txt"),
+		}, poll, func(path string) {
+			m.Lock()
+			defer m.Unlock()
+			paths = append(paths, filepath.Base(path))
+		})
+	}()
+
+	<-time.After(5 * poll)
+	appendFile(t, filepath.Join(dir, "b.log"), "")
+
+	assert.Eventually(t, func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return len(paths) == 3
+	}, time.Second, poll)
+
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"a.log", "later.txt", "b.log"}, paths)
+
+	assert.Error(t, tail.Watch(context.Background(), []string{"["}, poll, func(string) {}))
+}
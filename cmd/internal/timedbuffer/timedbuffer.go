@@ -1,6 +1,8 @@
 package timedbuffer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -9,7 +11,7 @@ import (
 )
 
 // Buffer represents a "buffer" that holds a buffer
-// of messages which are flushed in accordance with the
+// of values which are flushed in accordance with the
 // flush interval.
 //
 // It will gather values that are to be published until a
@@ -18,35 +20,48 @@ import (
 //
 // Close should be called to release resources to avoid
 // leaking the ticker.
-type Buffer struct {
+type Buffer[T any] struct {
 	// ticker ticks every time the duration interval
 	// passes.
 	ticker *time.Ticker
 
 	// flushCh is the channel over which a message batch
 	// is flushed.
-	flushCh chan []notification.Message
+	flushCh chan []T
 
 	// stopCh is used to stop the gatherer
 	// when the buffer is closed.
 	stopCh chan struct{}
 
+	// gathered is closed once the gatherer has
+	// stopped.
+	gathered chan struct{}
+
 	// buffer holds the buffered messages that
 	// have to be sent to the notifier.
 	//
 	// Note that this can grow unbounded if
 	// the interval is set large enough.
-	buffer []notification.Message
+	buffer []T
 
 	// max size that the buffer can grow to.
 	//
 	// Messages added to the buffer that exceed the size
 	// will be dropped.
 	size int
-	m    sync.Mutex
+
+	// freed is closed and replaced whenever messages
+	// are taken from the buffer, to wake up callers
+	// waiting for space.
+	freed chan struct{}
+
+	m sync.Mutex
 }
 
-// New constructs a new Buffer with the
+// errClosed is returned when waiting on a closed buffer.
+var errClosed = errors.New("buffer closed")
+
+// New constructs a new Buffer of messages with the
 // specified interval and size.
 //
 // It is important to specify an adequate interval
@@ -55,15 +70,25 @@ type Buffer struct {
 //
 // It spawns a go routine that keeps track of the
 // timer and gathers the messages added to the buffer.
-func New(interval time.Duration, size int) *Buffer {
+func New(interval time.Duration, size int) *Buffer[notification.Message] {
+	return NewOf[notification.Message](interval, size)
+}
+
+// NewOf constructs a new Buffer of values of any type,
+// such as messages along with their metadata.
+//
+// See New.
+func NewOf[T any](interval time.Duration, size int) *Buffer[T] {
 	t := time.NewTicker(interval)
 
-	b := &Buffer{
-		ticker:  t,
-		flushCh: make(chan []notification.Message),
-		stopCh:  make(chan struct{}),
-		m:       sync.Mutex{},
-		size:    size,
+	b := &Buffer[T]{
+		ticker:   t,
+		flushCh:  make(chan []T),
+		stopCh:   make(chan struct{}),
+		gathered: make(chan struct{}),
+		freed:    make(chan struct{}),
+		m:        sync.Mutex{},
+		size:     size,
 	}
 
 	// Start gathering messages.
@@ -80,9 +105,10 @@ func New(interval time.Duration, size int) *Buffer {
 // If no new messages have been added to the buffer,
 // the buffer is not flushed.
 //
-// Sends to the flushCh will never block.
-// Message batches will be dropped if there is no receiver.
-func (b *Buffer) gather() {
+// Sends to the flushCh block until there is a receiver
+// or the buffer is closed, so batches are never dropped.
+func (b *Buffer[T]) gather() {
+	defer close(b.gathered)
 	defer close(b.flushCh)
 
 	for {
@@ -96,12 +122,13 @@ func (b *Buffer) gather() {
 }
 
 // flush flushes all messages to flushCh.
-func (b *Buffer) flush() {
+func (b *Buffer[T]) flush() {
 	// Copy the buffer and release
 	// the lock.
 	b.m.Lock()
 	buf := b.buffer
 	b.buffer = nil
+	b.free()
 	b.m.Unlock()
 
 	if len(buf) == 0 {
@@ -110,18 +137,20 @@ func (b *Buffer) flush() {
 
 	// Send the batch to the flushCh.
 	//
-	// This is non-blocking so if there is
-	// no reader for the channel, batches
-	// will be discarded.
+	// If the buffer is closed before the batch is
+	// received, it is put back so that it can be
+	// taken with Drain.
 	select {
 	case b.flushCh <- buf:
-	default:
+	case <-b.stopCh:
+		b.m.Lock()
+		b.buffer = append(buf, b.buffer...)
+		b.m.Unlock()
 	}
-	buf = nil
 }
 
 // Append adds appends messages to the buffer.
-func (b *Buffer) Append(msgs ...notification.Message) error {
+func (b *Buffer[T]) Append(msgs ...T) error {
 	b.m.Lock()
 	defer b.m.Unlock()
 
@@ -138,14 +167,74 @@ func (b *Buffer) Append(msgs ...notification.Message) error {
 	return nil
 }
 
+// AppendWait behaves like Append, but waits for the
+// buffer to be flushed if the messages do not fit.
+//
+// It returns an error if ctx is done or the buffer is
+// closed first, or if the messages exceed the size
+// of the buffer.
+func (b *Buffer[T]) AppendWait(ctx context.Context, msgs ...T) error {
+	if len(msgs) > b.size {
+		return fmt.Errorf("max buffer size of %d exceeded", b.size)
+	}
+
+	for {
+		b.m.Lock()
+		if len(b.buffer)+len(msgs) <= b.size {
+			b.buffer = append(b.buffer, msgs...)
+			b.m.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.m.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.stopCh:
+			return errClosed
+		}
+	}
+}
+
+// Drain removes and returns all buffered messages.
+//
+// It can be used to send what is left in the buffer
+// without waiting for the next tick.
+func (b *Buffer[T]) Drain() []T {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	buf := b.buffer
+	b.buffer = nil
+	b.free()
+
+	return buf
+}
+
+// free wakes up callers waiting for space.
+//
+// It must be called with the lock held.
+func (b *Buffer[T]) free() {
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
 // FlushCh returns the channel to which message batches are
 // flushed to
-func (b *Buffer) FlushCh() <-chan []notification.Message {
+func (b *Buffer[T]) FlushCh() <-chan []T {
 	return b.flushCh
 }
 
 // Close stops the ticker and releases associated resources.
-func (b *Buffer) Close() {
+//
+// Messages that were not flushed yet remain in the
+// buffer and can be taken with Drain. It waits for a
+// batch that is being flushed to be put back, so that
+// it is not missed by Drain.
+func (b *Buffer[T]) Close() {
 	b.ticker.Stop()
 	close(b.stopCh)
+	<-b.gathered
 }
@@ -1,6 +1,7 @@
 package timedbuffer_test
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -121,3 +122,62 @@ func TestTimedBuffer_NoMessages(t *testing.T) {
 	case <-time.After(5 * time.Second):
 	}
 }
+
+func TestTimedBuffer_Drain(t *testing.T) {
+	t.Parallel()
+
+	tb := timedbuffer.New(100*time.Millisecond, 10)
+
+	assert.Nil(t, tb.Append("a", "b"))
+	assert.Equal(t, []notification.Message{"a", "b"}, tb.Drain())
+	assert.Empty(t, tb.Drain())
+
+	// Batches that are not received before the
+	// buffer is closed are kept, and can be taken
+	// right away.
+	assert.Nil(t, tb.Append("c"))
+	<-time.After(250 * time.Millisecond)
+	tb.Close()
+	assert.Equal(t, []notification.Message{"c"}, tb.Drain())
+
+	for range tb.FlushCh() {
+		assert.Fail(t, "expected no batches")
+	}
+}
+
+func TestTimedBuffer_AppendWait(t *testing.T) {
+	t.Parallel()
+
+	tb := timedbuffer.New(100*time.Millisecond, 2)
+	defer tb.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, tb.AppendWait(ctx, "a", "b"))
+
+	// This only fits once the buffer is flushed.
+	appended := make(chan error)
+	go func() {
+		appended <- tb.AppendWait(ctx, "c")
+	}()
+
+	select {
+	case <-appended:
+		assert.Fail(t, "expected append to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, []notification.Message{"a", "b"}, <-tb.FlushCh())
+	assert.Nil(t, <-appended)
+	assert.Equal(t, []notification.Message{"c"}, tb.Drain())
+
+	assert.Error(t, tb.AppendWait(ctx, "a", "b", "c"))
+
+	// A buffer that is not flushed in time.
+	full := timedbuffer.New(time.Minute, 1)
+	defer full.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, full.Append("a"))
+	assert.ErrorIs(t, full.AppendWait(ctx, "b"), context.DeadlineExceeded)
+}
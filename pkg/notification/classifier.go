@@ -0,0 +1,88 @@
+package notification
+
+import "net/http"
+
+// Outcome is the result of an attempt at sending a message.
+type Outcome int
+
+const (
+	// OutcomeSuccess means the message was delivered.
+	OutcomeSuccess Outcome = iota
+
+	// OutcomeRetryable means the message was not delivered,
+	// but sending it again may succeed.
+	OutcomeRetryable
+
+	// OutcomePermanent means the message was not delivered
+	// and sending it again will not succeed.
+	OutcomePermanent
+)
+
+// Verdict is the classification of a response.
+type Verdict struct {
+	Outcome Outcome
+
+	// Reason describes why a message was not delivered.
+	//
+	// It is included in the error returned for the message.
+	Reason string
+}
+
+// Response is the part of an HTTP response that is
+// available to a ResponseClassifier.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+
+	// Body holds the start of the response body, up to
+	// the limit set with WithResponseBodyLimit.
+	Body []byte
+}
+
+// ResponseClassifier decides whether a message was delivered
+// based on the response to sending it.
+type ResponseClassifier func(resp Response) Verdict
+
+// DefaultClassifier is the ResponseClassifier used unless
+// another is set with WithResponseClassifier.
+//
+// All 2xx responses are successful and all 5xx responses are
+// retryable, while other responses are permanent failures.
+// It does not inspect the response body.
+func DefaultClassifier(resp Response) Verdict {
+	switch s := resp.StatusCode; {
+	case is2XX(s):
+		return Verdict{Outcome: OutcomeSuccess}
+	case is5XX(s):
+		return Verdict{Outcome: OutcomeRetryable, Reason: "server error"}
+	default:
+		return Verdict{Outcome: OutcomePermanent, Reason: "unexpected status"}
+	}
+}
+
+// classify inspects the response to determine
+// if it was successful or not.
+//
+// If it wasn't successful, it returns an appropriate error
+// to indicate if clients can retry the request.
+//
+// The message that failed is also included as part of the
+// error.
+func classify(fn ResponseClassifier, resp Response, msg Message, attempt int) error {
+	v := fn(resp)
+	if v.Outcome == OutcomeSuccess {
+		return nil
+	}
+
+	return newRequestError(
+		resp.StatusCode, msg, attempt, v.Outcome == OutcomeRetryable, v.Reason,
+	)
+}
+
+func is2XX(status int) bool {
+	return status >= 200 && status < 300
+}
+
+func is5XX(status int) bool {
+	return status >= 500
+}
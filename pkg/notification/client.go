@@ -2,15 +2,22 @@ package notification
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/vivangkumar/notify/pkg/notification/internal/ratelimiter"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vivangkumar/notify/pkg/notification/internal/queue"
+	"github.com/vivangkumar/notify/pkg/notification/internal/ratelimiter"
 )
 
 // Client provides an interface to send notifications
@@ -22,6 +29,16 @@ type Client struct {
 	// can be passed.
 	httpClient httpClient
 
+	// transport is the transport of the default
+	// HTTP client.
+	//
+	// It is nil if WithHTTPClient is used.
+	transport *defaultTransport
+
+	// do sends requests via httpClient, wrapped
+	// with the configured middleware.
+	do SendFunc
+
 	// rl is the configured rate limiter.
 	//
 	// If not configured, a default rate limiter is used.
@@ -31,7 +48,7 @@ type Client struct {
 	cfg config
 
 	// msgs is the main message queue.
-	msgs chan Message
+	msgs *queue.Queue[envelope]
 
 	// errs is the channel via which clients can
 	// read errors from.
@@ -44,9 +61,45 @@ type Client struct {
 	// wg keeps track of worker go routines.
 	wg sync.WaitGroup
 
-	// logger and metrics are for observability and monitoring.
-	logger  *logrus.Logger
+	// workers holds a quit channel for every running
+	// worker, which is closed to stop that worker.
+	workers []chan struct{}
+
+	// started is set once Start has been called.
+	started bool
+
+	// stats tracks delivery outcomes for Stats.
+	stats *counters
+
+	// events publishes delivery events to subscribers.
+	events *eventHub
+
+	// drained measures the rate at which messages
+	// are taken off the message queue.
+	drained *rateMeter
+
+	// resumeTimer resumes the Client if it
+	// was paused with PauseUntil.
+	resumeTimer *time.Timer
+
+	// m guards the state that can be changed while
+	// the Client is running.
+	m sync.Mutex
+
+	// scheduler holds messages that are to be
+	// sent at a later time.
+	scheduler *scheduler
+
+	// dedup suppresses duplicate messages.
+	//
+	// It is nil if deduplication is disabled.
+	dedup *deduper
+
+	// logger, metrics and tracing are for observability
+	// and monitoring.
+	logger  Logger
 	metrics metrics
+	tracing tracing
 }
 
 // NewClient constructs a Client with the given url and options
@@ -73,24 +126,64 @@ func NewClient(url string, opts ...Opt) *Client {
 		maxBufferSize:         defaultBufferSize,
 		shutDownGraceDuration: defaultShutdownGraceDuration,
 		maxConcurrency:        defaultConcurrency,
+		maxScheduled:          defaultMaxScheduled,
+		maxRps:                defaultRateLimit,
+		refill:                1,
+		retryBackoff:          defaultRetryBackoff,
+		tracerProvider:        trace.NewNoopTracerProvider(),
+		propagator:            propagation.TraceContext{},
+		classifier:            DefaultClassifier,
+		responseBodyLimit:     defaultResponseBodyLimit,
+		responseDrainLimit:    defaultResponseDrainLimit,
+		transport: transportConfig{
+			idleConnTimeout: defaultIdleConnTimeout,
+			http2:           true,
+		},
+		metrics: metricsConfig{
+			latencyBuckets: prometheus.DefBuckets,
+			sizeBuckets:    defaultSizeBuckets,
+		},
 	}
 
 	c := &Client{
-		httpClient: &http.Client{},
-		rl:         ratelimiter.New(defaultRateLimit, 1),
-		cfg:        cfg,
-		done:       make(chan struct{}),
-		errs:       make(chan error),
-		msgs:       make(chan Message, defaultBufferSize),
-		wg:         sync.WaitGroup{},
-		metrics:    newMetrics(false, prometheus.NewRegistry()),
-		logger:     newLogger(false, defaultLogLevel),
+		rl:        ratelimiter.New(defaultRateLimit, 1),
+		cfg:       cfg,
+		done:      make(chan struct{}),
+		errs:      make(chan error),
+		scheduler: newScheduler(defaultMaxScheduled),
+		stats:     &counters{},
+		events:    newEventHub(),
+		drained:   newRateMeter(time.Now()),
+		wg:        sync.WaitGroup{},
+		logger:    newLogger(false, defaultLogLevel),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	if c.httpClient == nil {
+		c.transport = newDefaultTransport(c.cfg.transport, c.cfg.maxConcurrency)
+		c.httpClient = &http.Client{Transport: c.transport}
+	}
+
+	var order func(a, b envelope) bool
+	if c.cfg.earliestDeadlineFirst {
+		order = earlierDeadline
+	}
+	c.msgs = queue.New(c.cfg.maxBufferSize, byPriority(order))
+
+	c.do = chain(func(_ Message, req *http.Request) (*http.Response, error) {
+		return c.httpClient.Do(req)
+	}, c.cfg.middleware)
+
+	c.tracing = newTracing(c.cfg.tracerProvider, c.cfg.propagator)
+	c.metrics = newMetrics(c.cfg.metrics, metricSources{
+		queueDepth: func() float64 { return float64(c.msgs.Len()) },
+		scheduled:  func() float64 { return float64(c.scheduler.len()) },
+		inFlight:   func() float64 { return float64(c.stats.inFlightRequests()) },
+	})
+
 	return c
 }
 
@@ -105,71 +198,351 @@ func NewClient(url string, opts ...Opt) *Client {
 // message fails and indicate to the caller that they must retry.
 func (c *Client) Notify(msgs ...Message) error {
 	for _, msg := range msgs {
-		select {
-		case c.msgs <- msg:
-			c.logger.WithField("msg", msg).Debug("queuing message")
-		default:
-			c.logger.Info("failed to enqueue message")
-			c.metrics.incrEnqueueFailures()
-
-			return newEnqueueError(
-				fmt.Errorf(
-					"failed to enqueue message: %s", msg),
-			)
+		if err := c.enqueue(newEnvelope(msg)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// NotifyContext behaves like Notify, but links the spans
+// recorded for the delivery of the messages to the trace
+// in ctx.
+//
+// See WithContext.
+func (c *Client) NotifyContext(ctx context.Context, msgs ...Message) error {
+	for _, msg := range msgs {
+		if err := c.enqueue(newEnvelope(msg, WithContext(ctx))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NotifyWith enqueues a single message along with options
+// that configure its delivery.
+//
+// Messages scheduled with WithSendAt or WithDelay are held
+// by the Client until they are due. An error is returned
+// if too many messages are already scheduled.
+//
+// It behaves like Notify otherwise.
+func (c *Client) NotifyWith(msg Message, opts ...MessageOpt) error {
+	return c.enqueue(newEnvelope(msg, opts...))
+}
+
+// enqueue adds the envelope to the message queue.
+//
+// Duplicate messages are suppressed without an error
+// if deduplication is enabled.
+func (c *Client) enqueue(e envelope) (err error) {
+	span := c.tracing.enqueue(&e)
+	defer func() { endSpan(span, err) }()
+
+	if c.dedup != nil && !c.dedup.add(e) {
+		c.logger.Debug("suppressing duplicate message", e.logFields()...)
+		c.metrics.incrDuplicates()
+		return nil
+	}
+
+	now := time.Now()
+	if e.ttl == 0 {
+		e.ttl = c.cfg.defaultTTL
+	}
+	if e.deadline.IsZero() && e.ttl > 0 {
+		e.deadline = e.due(now).Add(e.ttl)
+	}
+
+	if e.sendAt.After(now) {
+		return c.schedule(e)
+	}
+
+	c.stats.accepted()
+	if c.push(e) {
+		c.logger.Debug("queuing message", e.logFields()...)
+		c.publish(EventEnqueued, e, 0, "", nil)
+	} else {
+		c.stats.settled()
+
+		// Forget the message so that a retry is not
+		// mistaken for a duplicate.
+		if c.dedup != nil {
+			c.dedup.remove(e)
+		}
+
+		c.logger.Info("failed to enqueue message", e.logFields()...)
+		c.metrics.incrEnqueueFailures()
+
+		return newEnqueueError(
+			fmt.Errorf(
+				"failed to enqueue message: %s", e.msg),
+			c.enqueueRetryAfter(),
+		)
+	}
+
+	return nil
+}
+
+// schedule holds the envelope until it is due.
+func (c *Client) schedule(e envelope) error {
+	c.stats.accepted()
+	if c.scheduler.add(e) {
+		c.logger.Debug(
+			"scheduling message",
+			append(e.logFields(), "send_at", e.sendAt)...,
+		)
+		c.publish(EventEnqueued, e, 0, "", nil)
+		return nil
+	}
+	c.stats.settled()
+
+	if c.dedup != nil {
+		c.dedup.remove(e)
+	}
+
+	c.logger.Info("failed to schedule message", e.logFields()...)
+	c.metrics.incrEnqueueFailures()
+
+	return newEnqueueError(
+		fmt.Errorf("failed to schedule message: %s", e.msg),
+		c.scheduleRetryAfter(),
+	)
+}
+
+// release moves a due envelope from the scheduler
+// to the message queue.
+//
+// It returns false if the queue is full.
+func (c *Client) release(e envelope) bool {
+	if !c.push(e) {
+		return false
+	}
+	c.logger.Debug("queuing scheduled message", e.logFields()...)
+
+	return true
+}
+
+// push adds the envelope to the message queue,
+// recording the time it was queued at.
+func (c *Client) push(e envelope) bool {
+	e.queuedAt = time.Now()
+	return c.msgs.Push(e)
+}
+
 // Start begins the worker pool.
 func (c *Client) Start() {
 	c.logger.Info("starting message consuming")
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
 	c.metrics.setClientMaxBufferSize(c.cfg.maxBufferSize)
+	c.metrics.setMaxConcurrency(c.cfg.maxConcurrency)
+	if c.cfg.maxRps > 0 {
+		c.metrics.setMaxRps(c.cfg.maxRps)
+	}
 
 	// Start the rate limiter
 	c.rl.Start()
+	c.msgs.Start()
+
+	c.started = true
+	c.scaleWorkers(c.cfg.maxConcurrency)
 
-	for i := 0; i < c.cfg.maxConcurrency; i++ {
-		go c.worker(i)
+	go c.scheduler.run(c.done, c.release)
+}
+
+// scaleWorkers starts or stops workers until n are running.
+//
+// Stopped workers finish sending their current message
+// before exiting.
+//
+// It must be called with the lock held.
+func (c *Client) scaleWorkers(n int) {
+	for i := len(c.workers); i < n; i++ {
+		quit := make(chan struct{})
+		c.workers = append(c.workers, quit)
+
+		c.wg.Add(1)
+		go c.worker(i, quit)
+	}
+
+	for len(c.workers) > n {
+		last := len(c.workers) - 1
+		close(c.workers[last])
+		c.workers = c.workers[:last]
 	}
 }
 
-func (c *Client) worker(i int) {
-	c.wg.Add(1)
+func (c *Client) worker(i int, quit <-chan struct{}) {
 	defer c.wg.Done()
 
-	c.logger.
-		WithField("worker_num", i).
-		Debug("starting worker")
+	c.logger.Debug("starting worker", logKeyWorker, i)
 
 	for {
 		select {
-		case msg, ok := <-c.msgs:
-			if !ok {
-				return
-			}
-
-			if err := c.retryRateLimit(); err != nil {
-				c.sendError(
-					fmt.Errorf("dropping msg: '%s': %w", msg, err),
-				)
-				continue
-			}
-
-			if err := c.send(msg); err != nil {
-				c.logger.
-					WithError(err).
-					Errorln("failed to send notification")
-				c.sendError(err)
-			}
+		case e := <-c.msgs.Out():
+			c.drained.mark(time.Now())
+			c.process(i, e, quit)
+		case <-quit:
+			c.logger.Debug("stopping worker", logKeyWorker, i)
+			return
+		case <-c.done:
+			c.logger.Debug("stopping worker", logKeyWorker, i)
+			return
+		}
+	}
+}
+
+// process sends a single message, retrying retryable
+// failures as configured with WithMaxRetries.
+func (c *Client) process(i int, e envelope, quit <-chan struct{}) {
+	defer c.stats.settled()
+
+	c.tracing.queueWait(e)
+
+	for attempt := 1; ; attempt++ {
+		if c.expire(i, e) {
+			return
+		}
+
+		if err := c.retryRateLimit(e, attempt); err != nil {
+			c.drop(i, e, dropReasonRateLimited, err)
+			return
+		}
+
+		// The message may have expired while
+		// waiting for the rate limiter.
+		if c.expire(i, e) {
+			return
+		}
+
+		c.stats.sending()
+		err := c.send(e, attempt)
+		c.stats.sent(err, time.Now())
+		if err == nil {
+			c.metrics.incrSent()
+			c.metrics.measureDeliveryLatency(e.queuedAt)
+			c.publish(EventSent, e, attempt, "", nil)
+			return
+		}
+
+		if !isRetryable(err) {
+			c.fail(i, e, attempt, failReasonPermanent, err)
+			return
+		}
+		if attempt > c.cfg.maxRetries {
+			c.fail(i, e, attempt, failReasonRetriesExhausted, err)
+			return
+		}
+
+		c.logger.Info(
+			"retrying notification",
+			append(
+				e.logFields(),
+				logKeyWorker, i,
+				logKeyAttempt, attempt,
+				logKeyStatus, statusOf(err),
+				logKeyError, err,
+			)...,
+		)
+		c.stats.retried()
+		c.metrics.incrRetries()
+		c.publish(EventRetried, e, attempt, "", err)
+
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-quit:
+			c.drop(i, e, dropReasonStopped, err)
+			return
 		case <-c.done:
-			c.logger.Debug("stopping worker")
+			c.drop(i, e, dropReasonStopped, err)
 			return
 		}
 	}
 }
 
+// backoff returns the duration to wait before retrying
+// after the given attempt.
+//
+// The duration doubles with every attempt, up to
+// maxRetryBackoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.retryBackoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+
+	return d
+}
+
+// isRetryable reports whether err is a behavioural
+// error that can be retried.
+func isRetryable(err error) bool {
+	var re interface{ IsRetryable() bool }
+	return errors.As(err, &re) && re.IsRetryable()
+}
+
+// expire drops the envelope if its deadline has passed.
+//
+// It returns true if the envelope was dropped.
+func (c *Client) expire(i int, e envelope) bool {
+	if !e.expired(time.Now()) {
+		return false
+	}
+
+	c.logger.Info(
+		"dropping expired message",
+		append(e.logFields(), logKeyWorker, i, "deadline", e.deadline)...,
+	)
+	c.stats.dropped(time.Now())
+	c.metrics.incrDropped(dropReasonExpired)
+
+	err := newExpiredError(e.msg, e.deadline)
+	c.publish(EventExpired, e, 0, dropReasonExpired, err)
+	c.sendError(err)
+
+	return true
+}
+
+// drop records a message that is given up on
+// before it could be sent.
+func (c *Client) drop(i int, e envelope, reason string, err error) {
+	c.logger.Info(
+		"dropping message",
+		append(e.logFields(), logKeyWorker, i, "reason", reason, logKeyError, err)...,
+	)
+	c.stats.dropped(time.Now())
+	c.metrics.incrDropped(reason)
+
+	err = fmt.Errorf("dropping msg: '%s': %w", e.msg, err)
+	c.publish(EventDropped, e, 0, reason, err)
+	c.sendError(err)
+}
+
+// fail records a message that could not be sent.
+func (c *Client) fail(i int, e envelope, attempt int, reason string, err error) {
+	c.logger.Error(
+		"failed to send notification",
+		append(
+			e.logFields(),
+			logKeyWorker, i,
+			logKeyAttempt, attempt,
+			logKeyStatus, statusOf(err),
+			logKeyError, err,
+		)...,
+	)
+	c.stats.fail()
+	c.metrics.incrFailed(reason)
+	c.publish(EventFailed, e, attempt, reason, err)
+	c.sendError(err)
+}
+
 // sendError attempts to send errors via the error channel
 // in a non-blocking way.
 //
@@ -178,9 +551,7 @@ func (c *Client) sendError(err error) {
 	select {
 	case c.errs <- err:
 	default:
-		c.logger.
-			WithField("error", err.Error()).
-			Debug("dropping error")
+		c.logger.Debug("dropping error", logKeyError, err)
 	}
 }
 
@@ -189,19 +560,27 @@ func (c *Client) sendError(err error) {
 //
 // It will give up if the client is stopped or
 // if the retry duration has elapsed.
-func (c *Client) retryRateLimit() error {
-	allowed := c.rl.Add
+func (c *Client) retryRateLimit(e envelope, attempt int) (err error) {
+	start := time.Now()
+	defer c.metrics.measureRateLimitWait(start)
+
+	span := c.tracing.rateLimitWait(e, attempt)
+	defer func() { endSpan(span, err) }()
+
+	timeout := time.NewTimer(defaultRateLimitRetryDuration)
+	defer timeout.Stop()
 
 	for {
+		if c.rl.Add() {
+			return nil
+		}
+
 		select {
 		case <-c.done:
 			return nil
-		case <-time.After(defaultRateLimitRetryDuration):
+		case <-timeout.C:
 			return fmt.Errorf("rate limit reached")
-		default:
-			if allowed() {
-				return nil
-			}
+		case <-time.After(defaultRateLimitPollInterval):
 		}
 	}
 }
@@ -213,28 +592,71 @@ func (c *Client) retryRateLimit() error {
 //
 // Errors are dropped if callers are not reading
 // from this channel.
+//
+// Deprecated: Use Subscribe, which buffers events for
+// every subscriber and also reports successes.
 func (c *Client) Errors() <-chan error {
 	return c.errs
 }
 
 // Stop gracefully shuts down the Client.
 //
+// Messages that are still queued, scheduled or waiting
+// to be retried are discarded, including while the
+// Client is paused.
+// They are counted as dropped and published as
+// EventDropped with the "stopped" reason, so that
+// subscribers can persist them. Call Drain first to
+// deliver them instead.
+//
 // It may return an error if the client cannot
 // gracefully exit within the grace period.
 func (c *Client) Stop() error {
 	var err error
 
+	c.m.Lock()
+	c.stopResumeTimer()
+	c.m.Unlock()
+
 	c.rl.Stop()
 	close(c.done)
 	err = c.waitWithTimeout()
-	close(c.errs)
-	close(c.msgs)
 
-	c.logger.WithError(err).Info("client stopped")
+	// Workers that did not exit within the grace period
+	// may still report errors, so the channel is only
+	// closed once all of them are done.
+	if err == nil {
+		close(c.errs)
+	}
+	c.msgs.Stop()
+	c.discard()
+	c.events.close()
+
+	c.logger.Info("client stopped", logKeyError, err)
 
 	return err
 }
 
+// discard drops all messages that are still
+// scheduled or queued.
+func (c *Client) discard() {
+	discarded := append(c.scheduler.drain(), c.msgs.Drain()...)
+	if len(discarded) == 0 {
+		return
+	}
+
+	c.logger.Info("discarding undelivered messages", "count", len(discarded))
+	for _, e := range discarded {
+		c.stats.dropped(time.Now())
+		c.stats.settled()
+		c.metrics.incrDropped(dropReasonStopped)
+		c.publish(
+			EventDropped, e, 0, dropReasonStopped,
+			fmt.Errorf("dropping msg: '%s': %w", e.msg, errStopped),
+		)
+	}
+}
+
 // MetricsRegistry returns the Prometheus metrics registry.
 //
 // This can be used by callers to report metrics via
@@ -244,23 +666,6 @@ func (c *Client) MetricsRegistry() *prometheus.Registry {
 	return c.metrics.registry()
 }
 
-// newLogger creates and configures a logger.
-//
-// By default, it uses the info log level.
-// It always logs in a structured format.
-func newLogger(enabled bool, level logrus.Level) *logrus.Logger {
-	logger := logrus.New()
-	logger.SetLevel(level)
-	logger.SetFormatter(&logrus.TextFormatter{})
-
-	// Discard log output if disabled.
-	if !enabled {
-		logger.SetOutput(ioutil.Discard)
-	}
-
-	return logger
-}
-
 // waitWithTimeout ensures that the Client exits
 // within the grace period it is configured with.
 //
@@ -287,62 +692,60 @@ func (c *Client) waitWithTimeout() error {
 // the url provided in the configuration of the Client.
 //
 // The body of the request is the message passed to it.
+// The request is sent through the configured middleware.
 //
-// Given we don't know what content type the upstream service
-// will return response bodies in, this function does not
-// read the response body.
-//
-// It detects errors by checking the status codes returned.
-func (c *Client) send(msg Message) error {
+// It detects errors by passing the status code, headers and
+// the start of the response body to the configured classifier.
+func (c *Client) send(e envelope, attempt int) (err error) {
 	req, err := http.NewRequest(
 		http.MethodPost,
 		c.cfg.url,
-		bytes.NewBuffer([]byte(msg)),
+		bytes.NewBuffer([]byte(e.msg)),
 	)
 	if err != nil {
 		return fmt.Errorf("construct request: %w", err)
 	}
-
-	start := time.Now()
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+	for k, vs := range e.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
 	}
-	defer resp.Body.Close()
-	c.metrics.measureHTTPLatency(start, resp.Status)
 
-	return classifyStatus(resp.StatusCode, msg)
-}
+	span := c.tracing.send(e, attempt, req)
+	defer func() { endSpan(span, err) }()
 
-// classifyStatus inspects the request status to determine
-// if it was successful or not.
-//
-// If it wasn't successful, it returns an appropriate error
-// to indicate if clients can retry the request.
-//
-// The message that failed is also included as part of the
-// error.
-//
-// All 5xx errors are retryable, while other errors are
-// by default non retryable.
-func classifyStatus(status int, msg Message) error {
-	retryable := false
+	req = c.traceConnections(req)
 
-	switch s := status; {
-	case is2XX(s):
-		return nil
-	case is5XX(status):
-		retryable = true
-	default:
-	}
+	c.metrics.observePayloadSize(len(e.msg))
 
-	return newRequestError(status, msg, retryable)
-}
+	start := time.Now()
+	resp, err := c.do(e.msg, req)
+	if err != nil {
+		// A middleware may return the response
+		// along with the error.
+		if resp != nil && resp.Body != nil {
+			_ = resp.Body.Close()
+		}
+		return newTransportError(err, e.msg, attempt)
+	}
+	if resp == nil {
+		return fmt.Errorf("send request: %w", errNoResponse)
+	}
+	if resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	defer drainAndClose(resp.Body, c.cfg.responseDrainLimit)
+	c.metrics.measureHTTPLatency(start, statusLabel(resp))
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 
-func is2XX(status int) bool {
-	return status >= 200 && status < 300
-}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.cfg.responseBodyLimit))
+	if err != nil {
+		return newTransportError(fmt.Errorf("read response: %w", err), e.msg, attempt)
+	}
 
-func is5XX(status int) bool {
-	return status >= 500
+	return classify(c.cfg.classifier, Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, e.msg, attempt)
 }
@@ -1,13 +1,24 @@
 package notification_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/vivangkumar/notify/pkg/notification"
 	"github.com/vivangkumar/notify/pkg/notification/internal/mocks"
@@ -89,6 +100,94 @@ func TestClient_Notify_Errors(t *testing.T) {
 	}
 }
 
+func TestClient_Notify_TransportErrors(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+
+	// Grab a free port and release it so that
+	// connections to it are refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	closedURL := "http://" + l.Addr().String()
+	assert.Nil(t, l.Close())
+
+	tests := []struct {
+		name          string
+		url           string
+		httpClient    *http.Client
+		wantKind      string
+		wantRetryable bool
+	}{
+		{
+			name:          "refused",
+			url:           closedURL,
+			httpClient:    &http.Client{},
+			wantKind:      "refused",
+			wantRetryable: true,
+		},
+		{
+			name:          "timeout",
+			url:           server.URL + "/long",
+			httpClient:    &http.Client{Timeout: 50 * time.Millisecond},
+			wantKind:      "timeout",
+			wantRetryable: true,
+		},
+		{
+			name:          "truncated",
+			url:           server.URL + "/truncated",
+			httpClient:    &http.Client{},
+			wantKind:      "reset",
+			wantRetryable: true,
+		},
+		{
+			name:          "tls",
+			url:           tlsServer.URL,
+			httpClient:    &http.Client{},
+			wantKind:      "tls",
+			wantRetryable: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := notification.NewClient(
+				tc.url,
+				notification.WithHTTPClient(tc.httpClient),
+				notification.WithMaxRetries(1, 10*time.Millisecond),
+			)
+			client.Start()
+
+			assert.Nil(t, client.Notify("hello"))
+
+			err := <-client.Errors()
+			var te interface {
+				requestError
+				Message() string
+				Attempt() int
+				Kind() string
+			}
+			if assert.True(t, errors.As(err, &te)) {
+				assert.Equal(t, tc.wantKind, te.Kind())
+				assert.Equal(t, tc.wantRetryable, te.IsRetryable())
+				assert.Equal(t, "hello", te.Message())
+
+				wantAttempt := 1
+				if tc.wantRetryable {
+					wantAttempt = 2
+				}
+				assert.Equal(t, wantAttempt, te.Attempt())
+			}
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
 func TestClient_Notify_Enqueue_Fail(t *testing.T) {
 	t.Parallel()
 
@@ -97,20 +196,1383 @@ func TestClient_Notify_Enqueue_Fail(t *testing.T) {
 
 	client := notification.NewClient(
 		server.URL+"/notification",
-		notification.WithMaxBufferSize(1),
-		notification.WithMaxConcurrency(1),
+		notification.WithMaxBufferSize(1),
+		notification.WithMaxConcurrency(1),
+	)
+	client.Start()
+
+	// Buffer size is 1.
+	assert.Nil(t, client.Notify("hello"))
+
+	// This should fail
+	err := client.Notify("hello2")
+	assert.Error(t, err)
+
+	var te temporaryError
+	assert.True(t, errors.As(err, &te) && te.IsTemporary())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Notify_Enqueue_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	// The client is not started, so that the queue
+	// does not drain and the rate limit is used.
+	client := notification.NewClient(
+		"http://localhost",
+		notification.WithMaxBufferSize(20),
+		notification.WithMaxRpsAndRefill(10, 1),
+	)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, client.Notify(fmt.Sprintf("msg%d", i)))
+	}
+
+	// A tenth of the queue drains in 2/10s.
+	err := client.Notify("full")
+	var te temporaryError
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, 200*time.Millisecond, te.RetryAfter())
+	}
+
+	// Nothing drains while paused.
+	client.Pause()
+	err = client.Notify("full")
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, 3*time.Second, te.RetryAfter())
+	}
+}
+
+func TestClient_Notify_Enqueue_RetryAfter_Refill(t *testing.T) {
+	t.Parallel()
+
+	// Refilling 5 tokens 10 times per second allows
+	// 50 messages per second.
+	client := notification.NewClient(
+		"http://localhost",
+		notification.WithMaxBufferSize(20),
+		notification.WithMaxRpsAndRefill(10, 5),
+	)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, client.Notify(fmt.Sprintf("msg%d", i)))
+	}
+
+	// A tenth of the queue drains in 2/50s.
+	err := client.Notify("full")
+	var te temporaryError
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, 40*time.Millisecond, te.RetryAfter())
+	}
+
+	// The rate is changed along with the limit.
+	assert.Nil(t, client.SetRateLimit(10, 2))
+	err = client.Notify("full")
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, 100*time.Millisecond, te.RetryAfter())
+	}
+}
+
+func TestClient_Notify_Deduplication(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		by       notification.DedupKey
+		notify   func(c *notification.Client) error
+		wantSent int
+	}{
+		{
+			name: "same id",
+			by:   notification.DedupByID,
+			notify: func(c *notification.Client) error {
+				for _, msg := range []string{"a", "b"} {
+					err := c.NotifyWith(msg, notification.WithMessageID("1"))
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantSent: 1,
+		},
+		{
+			name: "different ids",
+			by:   notification.DedupByID,
+			notify: func(c *notification.Client) error {
+				err := c.NotifyWith("a", notification.WithMessageID("1"))
+				if err != nil {
+					return err
+				}
+				return c.NotifyWith("a", notification.WithMessageID("2"))
+			},
+			wantSent: 2,
+		},
+		{
+			name: "same content",
+			by:   notification.DedupByContent,
+			notify: func(c *notification.Client) error {
+				return c.Notify("a", "a", "b")
+			},
+			wantSent: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mc := mocks.NewHTTPClient()
+			client := notification.NewClient(
+				server.URL+"/notification",
+				notification.WithHTTPClient(mc),
+				notification.WithDeduplication(tc.by, time.Minute, 10),
+			)
+			client.Start()
+
+			assert.Nil(t, tc.notify(client))
+
+			assertChNoErrors(t, client.Errors(), 1*time.Second)
+			assert.Equal(t, tc.wantSent, mc.CallCount())
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func TestClient_Notify_Deduplication_Defaults(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		window  time.Duration
+		maxKeys int
+	}{
+		{
+			name: "zero",
+		},
+		{
+			name:    "negative",
+			window:  -time.Minute,
+			maxKeys: -1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mc := mocks.NewHTTPClient()
+			client := notification.NewClient(
+				server.URL+"/notification",
+				notification.WithHTTPClient(mc),
+				notification.WithDeduplication(notification.DedupByContent, tc.window, tc.maxKeys),
+			)
+			client.Start()
+
+			// The defaults are used instead, rather than
+			// not remembering any key.
+			assert.Nil(t, client.Notify("a", "a", "b"))
+
+			assertChNoErrors(t, client.Errors(), 1*time.Second)
+			assert.Equal(t, 2, mc.CallCount())
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func TestClient_Notify_Deduplication_Window(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+		notification.WithDeduplication(notification.DedupByContent, 500*time.Millisecond, 10),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("a"))
+	<-time.After(1 * time.Second)
+
+	// The window has elapsed, so this is not a duplicate.
+	assert.Nil(t, client.Notify("a"))
+
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+	assert.Equal(t, 2, mc.CallCount())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_NotifyWith_Scheduled(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+	)
+	client.Start()
+
+	err := client.NotifyWith("later", notification.WithDelay(2*time.Second))
+	assert.Nil(t, err)
+
+	err = client.NotifyWith("sooner", notification.WithSendAt(time.Now().Add(1*time.Second)))
+	assert.Nil(t, err)
+
+	err = client.NotifyWith("now", notification.WithSendAt(time.Now().Add(-1*time.Second)))
+	assert.Nil(t, err)
+
+	<-time.After(500 * time.Millisecond)
+	assert.Equal(t, 1, mc.CallCount())
+
+	<-time.After(1 * time.Second)
+	assert.Equal(t, 2, mc.CallCount())
+
+	assertChNoErrors(t, client.Errors(), 1500*time.Millisecond)
+	assert.Equal(t, 3, mc.CallCount())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_NotifyWith_Scheduled_Full(t *testing.T) {
+	t.Parallel()
+
+	client := notification.NewClient(
+		"http://localhost",
+		notification.WithMaxScheduled(1),
+	)
+
+	err := client.NotifyWith("a", notification.WithDelay(time.Minute))
+	assert.Nil(t, err)
+
+	err = client.NotifyWith("b", notification.WithDelay(time.Minute))
+	assert.Error(t, err)
+
+	// A slot frees up once the first message is due,
+	// which is capped at 30s.
+	var te temporaryError
+	assert.True(t, errors.As(err, &te) && te.IsTemporary())
+	assert.Equal(t, 30*time.Second, te.RetryAfter())
+}
+
+func TestClient_NotifyWith_Expired(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+	)
+	client.Start()
+
+	err := client.NotifyWith("stale", notification.WithDeadline(time.Now().Add(-1*time.Second)))
+	assert.Nil(t, err)
+
+	err = <-client.Errors()
+	assert.Error(t, err)
+
+	var ee expiredError
+	assert.True(t, errors.As(err, &ee) && ee.IsExpired())
+	assert.Equal(t, 0, mc.CallCount())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Notify_DefaultTTL(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+		notification.WithMaxConcurrency(1),
+		notification.WithMaxRpsAndRefill(1, 1),
+		notification.WithDefaultTTL(200*time.Millisecond),
+	)
+	client.Start()
+
+	// The second message waits for the rate limiter
+	// for longer than its time to live.
+	err := client.Notify("msg1", "msg2")
+	assert.Nil(t, err)
+
+	err = <-client.Errors()
+	var ee expiredError
+	assert.True(t, errors.As(err, &ee))
+	assert.Equal(t, "msg2", ee.Message())
+	assert.Equal(t, 1, mc.CallCount())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Notify_EarliestDeadlineFirst(t *testing.T) {
+	t.Parallel()
+
+	var (
+		m    sync.Mutex
+		msgs []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+
+		m.Lock()
+		msgs = append(msgs, string(b))
+		m.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL,
+		notification.WithMaxConcurrency(1),
+		notification.WithEarliestDeadlineFirst(),
+	)
+
+	now := time.Now()
+	assert.Nil(t, client.NotifyWith("none"))
+	assert.Nil(t, client.NotifyWith("late", notification.WithDeadline(now.Add(time.Hour))))
+	assert.Nil(t, client.NotifyWith("early", notification.WithDeadline(now.Add(time.Minute))))
+
+	client.Start()
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+
+	m.Lock()
+	assert.Equal(t, []string{"early", "late", "none"}, msgs)
+	m.Unlock()
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_NotifyWith_Priority(t *testing.T) {
+	t.Parallel()
+
+	var (
+		m    sync.Mutex
+		msgs []string
+		keys []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+
+		m.Lock()
+		msgs = append(msgs, string(b))
+		keys = append(keys, req.Header.Get("X-Key"))
+		m.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL,
+		notification.WithMaxConcurrency(1),
+	)
+
+	assert.Nil(t, client.NotifyWith("low", notification.WithPriority(-1)))
+	assert.Nil(t, client.NotifyWith("default"))
+	assert.Nil(t, client.NotifyWith("high", notification.WithPriority(10), notification.WithHeader("X-Key", "k1")))
+
+	client.Start()
+	assert.Nil(t, client.Drain(context.Background()))
+
+	m.Lock()
+	assert.Equal(t, []string{"high", "default", "low"}, msgs)
+	assert.Equal(t, []string{"k1", "", ""}, keys)
+	m.Unlock()
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Reconfigure(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+		notification.WithMaxBufferSize(1),
+		notification.WithMaxConcurrency(1),
+		notification.WithMaxRpsAndRefill(1, 0),
+		notification.WithMetrics(reg),
+	)
+
+	// Fill the buffer before starting.
+	assert.Nil(t, client.Notify("msg1"))
+	assert.Error(t, client.Notify("msg2"))
+
+	assert.Nil(t, client.SetMaxBufferSize(3))
+	assert.Nil(t, client.Notify("msg2", "msg3"))
+
+	client.Start()
+
+	// With no refill, only a single message goes through.
+	<-time.After(500 * time.Millisecond)
+	assert.Equal(t, 1, mc.CallCount())
+
+	assert.Nil(t, client.SetRateLimit(100, 100))
+	assert.Nil(t, client.SetMaxConcurrency(4))
+
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+	assert.Equal(t, 3, mc.CallCount())
+
+	assert.Equal(t, 3.0, gaugeValue(t, reg, "max_buffer_size"))
+	assert.Equal(t, 4.0, gaugeValue(t, reg, "max_concurrency"))
+	assert.Equal(t, 100.0, gaugeValue(t, reg, "max_rps"))
+
+	assert.Nil(t, client.SetMaxConcurrency(1))
+	assert.Error(t, client.SetMaxConcurrency(0))
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_SetRateLimit_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	client := notification.NewClient(
+		"http://localhost",
+		notification.WithRateLimiter(fixedRateLimiter{}),
+	)
+
+	assert.Error(t, client.SetRateLimit(10, 1))
+}
+
+func TestClient_Pause(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+		notification.WithMetrics(reg),
+	)
+	client.Start()
+
+	client.Pause()
+	assert.True(t, client.Paused())
+	assert.Equal(t, 1.0, gaugeValue(t, reg, "paused"))
+
+	assert.Nil(t, client.Notify("msg1", "msg2"))
+	<-time.After(500 * time.Millisecond)
+	assert.Equal(t, 0, mc.CallCount())
+
+	client.Resume()
+	assert.False(t, client.Paused())
+	assert.Equal(t, 0.0, gaugeValue(t, reg, "paused"))
+
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+	assert.Equal(t, 2, mc.CallCount())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_PauseUntil(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+	)
+	client.Start()
+
+	client.PauseUntil(time.Now().Add(1 * time.Second))
+	assert.Nil(t, client.Notify("msg1"))
+
+	<-time.After(500 * time.Millisecond)
+	assert.Equal(t, 0, mc.CallCount())
+
+	assertChNoErrors(t, client.Errors(), 1500*time.Millisecond)
+	assert.False(t, client.Paused())
+	assert.Equal(t, 1, mc.CallCount())
+
+	// Stopping while paused discards queued messages.
+	client.Pause()
+	assert.Nil(t, client.Notify("msg2"))
+	assert.Nil(t, client.Stop())
+	assert.Equal(t, 1, mc.CallCount())
+}
+
+func TestClient_Drain(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithMaxConcurrency(2),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("a", "b", "c"))
+	assert.Nil(t, client.NotifyWith("d", notification.WithDelay(50*time.Millisecond)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, client.Drain(ctx))
+
+	stats := client.Stats()
+	assert.Equal(t, uint64(4), stats.Delivered)
+	assert.Equal(t, 0, stats.Pending)
+
+	// A paused client does not drain.
+	client.Pause()
+	assert.Nil(t, client.Notify("e"))
+	assert.Equal(t, 1, client.Stats().Pending)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.Drain(ctx), context.DeadlineExceeded)
+
+	client.Resume()
+	assert.Nil(t, client.Drain(context.Background()))
+
+	// Stop discards queued and scheduled messages,
+	// reporting them as dropped.
+	sub := client.Subscribe(notification.WithEventTypes(notification.EventDropped))
+	client.Pause()
+	assert.Nil(t, client.Notify("f"))
+	assert.Nil(t, client.NotifyWith("g", notification.WithDelay(time.Minute)))
+	assert.Nil(t, client.Stop())
+
+	var dropped []notification.Message
+	for ev := range sub.Events() {
+		assert.Equal(t, "stopped", ev.Reason)
+		dropped = append(dropped, ev.Message)
+	}
+	assert.ElementsMatch(t, []notification.Message{"f", "g"}, dropped)
+
+	stats = client.Stats()
+	assert.Equal(t, uint64(2), stats.Dropped)
+	assert.Equal(t, 0, stats.Pending)
+	assert.Nil(t, client.Drain(context.Background()))
+}
+
+func TestClient_Stats(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithMaxBufferSize(10),
+		notification.WithMaxConcurrency(2),
+	)
+
+	assert.Nil(t, client.Notify("msg1", "msg2"))
+	assert.Nil(t, client.NotifyWith("later", notification.WithDelay(time.Minute)))
+
+	stats := client.Stats()
+	assert.Equal(t, 2, stats.QueueLength)
+	assert.Equal(t, 10, stats.QueueCapacity)
+	assert.Equal(t, 1, stats.Scheduled)
+	assert.Equal(t, 0, stats.Workers)
+
+	client.Start()
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+
+	stats = client.Stats()
+	assert.Equal(t, 0, stats.QueueLength)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 2, stats.Workers)
+	assert.Equal(t, uint64(2), stats.Delivered)
+	assert.Equal(t, uint64(0), stats.Failed)
+	assert.True(t, stats.LastErrorTime.IsZero())
+	assert.GreaterOrEqual(t, stats.RateLimiterTokens, int64(0))
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Notify_Retries(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/error-500",
+		notification.WithHTTPClient(mc),
+		notification.WithMaxRetries(2, 10*time.Millisecond),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("hello"))
+
+	err := <-client.Errors()
+	var re requestError
+	assert.True(t, errors.As(err, &re) && re.IsRetryable())
+	assert.Equal(t, 3, mc.CallCount())
+
+	stats := client.Stats()
+	assert.Equal(t, uint64(2), stats.Retried)
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.Equal(t, uint64(0), stats.Delivered)
+	assert.False(t, stats.LastErrorTime.IsZero())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Notify_Retries_Invalid(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		n       int
+		backoff time.Duration
+		calls   int
+		wait    time.Duration
+	}{
+		{
+			name:    "negative count",
+			n:       -1,
+			backoff: 10 * time.Millisecond,
+			calls:   1,
+		},
+		{
+			name:    "negative backoff",
+			n:       1,
+			backoff: -time.Second,
+			calls:   2,
+			wait:    100 * time.Millisecond,
+		},
+		{
+			name:  "zero backoff",
+			n:     1,
+			calls: 2,
+			wait:  100 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mc := mocks.NewHTTPClient()
+			client := notification.NewClient(
+				server.URL+"/error-500",
+				notification.WithHTTPClient(mc),
+				notification.WithMaxRetries(tt.n, tt.backoff),
+			)
+			client.Start()
+
+			start := time.Now()
+			assert.Nil(t, client.Notify("hello"))
+
+			// The retry waits for the default backoff
+			// rather than none at all.
+			assert.NotNil(t, <-client.Errors())
+			assert.GreaterOrEqual(t, time.Since(start), tt.wait)
+			assert.Equal(t, tt.calls, mc.CallCount())
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func TestClient_Stop_Retrying(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL+"/error-500",
+		notification.WithMaxRetries(1, time.Hour),
+	)
+	sub := client.Subscribe(notification.WithEventTypes(
+		notification.EventRetried,
+		notification.EventDropped,
+	))
+	client.Start()
+
+	assert.Nil(t, client.NotifyWith("hello", notification.WithMessageID("id-1")))
+
+	// Stop while the message waits to be retried.
+	ev := <-sub.Events()
+	assert.Equal(t, notification.EventRetried, ev.Type)
+	assert.Nil(t, client.Stop())
+
+	ev = <-sub.Events()
+	assert.Equal(t, notification.EventDropped, ev.Type)
+	assert.Equal(t, "id-1", ev.MessageID)
+	assert.Equal(t, "stopped", ev.Reason)
+
+	stats := client.Stats()
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 0, stats.Pending)
+}
+
+func TestClient_Metrics(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	newClient := func(name, path string) *notification.Client {
+		return notification.NewClient(
+			server.URL+path,
+			notification.WithMetrics(reg),
+			notification.WithMetricsNamespace("notify"),
+			notification.WithMetricsLabels(map[string]string{"client": name}),
+			notification.WithLatencyBuckets([]float64{0.1, 1}),
+		)
+	}
+
+	// Both clients share a registry.
+	ok := newClient("ok", "/notification")
+	failing := newClient("failing", "/error-400")
+	ok.Start()
+	failing.Start()
+
+	assert.Nil(t, ok.Notify("msg1", "msg2"))
+	assert.Nil(t, failing.Notify("msg1"))
+
+	assert.Eventually(t, func() bool {
+		return ok.Stats().Delivered == 2 && failing.Stats().Failed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				key += "," + l.GetName() + "=" + l.GetValue()
+			}
+
+			switch {
+			case m.Counter != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.Histogram != nil:
+				values[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	assert.Equal(t, 2.0, values["notify_messages_sent_total,client=ok"])
+	assert.Equal(t, 1.0, values["notify_messages_failed_total,client=failing,reason=permanent"])
+	assert.Equal(t, 2.0, values["notify_delivery_latency_duration_seconds,client=ok"])
+	assert.Equal(t, 2.0, values["notify_payload_size_bytes,client=ok"])
+	assert.Equal(t, 2.0, values["notify_http_request_latency_duration_seconds,client=ok,status=201 Created"])
+	assert.Equal(t, 1.0, values["notify_http_request_latency_duration_seconds,client=failing,status=400 Bad Request"])
+	assert.Equal(t, 2.0, values["notify_connections_total,client=ok,reused=false"]+
+		values["notify_connections_total,client=ok,reused=true"])
+
+	// Series that predate the namespace keep their names.
+	assert.Contains(t, values, "notify_notify_timeout_total,client=ok")
+	assert.Contains(t, values, "notify_messages_expired_total,client=ok")
+
+	assert.Nil(t, ok.Stop())
+	assert.Nil(t, failing.Stop())
+}
+
+func TestClient_NotifyContext_Tracing(t *testing.T) {
+	t.Parallel()
+
+	traceparents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceparents <- req.Header.Get("traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	client := notification.NewClient(
+		server.URL,
+		notification.WithTracerProvider(tp),
+	)
+	client.Start()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "producer")
+	assert.Nil(t, client.NotifyContext(ctx, "hello"))
+	parent.End()
+
+	var traceparent string
+	select {
+	case traceparent = <-traceparents:
+	case <-time.After(3 * time.Second):
+		assert.FailNow(t, "timed out waiting for request")
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(sr.Ended()) == 5
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Nil(t, client.Stop())
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range sr.Ended() {
+		spans[span.Name()] = span
+	}
+
+	traceID := parent.SpanContext().TraceID()
+	for _, name := range []string{
+		"notification.enqueue",
+		"notification.queue_wait",
+		"notification.rate_limit_wait",
+		"notification.send",
+	} {
+		span, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, traceID, span.SpanContext().TraceID(), name)
+		}
+	}
+
+	enqueue := spans["notification.enqueue"]
+	assert.Equal(t, parent.SpanContext().SpanID(), enqueue.Parent().SpanID())
+
+	send := spans["notification.send"]
+	assert.Equal(t, enqueue.SpanContext().SpanID(), send.Parent().SpanID())
+	assert.Equal(
+		t,
+		fmt.Sprintf("00-%s-%s-01", traceID, send.SpanContext().SpanID()),
+		traceparent,
+	)
+}
+
+func TestClient_Middleware(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	var (
+		m     sync.Mutex
+		order []string
+	)
+	record := func(name string) notification.Middleware {
+		return func(next notification.SendFunc) notification.SendFunc {
+			return func(msg notification.Message, req *http.Request) (*http.Response, error) {
+				m.Lock()
+				order = append(order, name+":before")
+				m.Unlock()
+
+				resp, err := next(msg, req)
+
+				m.Lock()
+				order = append(order, name+":after")
+				m.Unlock()
+
+				return resp, err
+			}
+		}
+	}
+
+	mc := mocks.NewHTTPClient()
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithHTTPClient(mc),
+		notification.WithMiddleware(record("a"), record("b")),
+		notification.WithMiddleware(record("c")),
 	)
 	client.Start()
 
-	// Buffer size is 1.
 	assert.Nil(t, client.Notify("hello"))
+	assertChNoErrors(t, client.Errors(), 1*time.Second)
+	assert.Equal(t, 1, mc.CallCount())
 
-	// This should fail
-	err := client.Notify("hello2")
-	assert.Error(t, err)
+	m.Lock()
+	assert.Equal(t, []string{
+		"a:before", "b:before", "c:before",
+		"c:after", "b:after", "a:after",
+	}, order)
+	m.Unlock()
 
-	var te temporaryError
-	assert.True(t, errors.As(err, &te) && te.IsTemporary())
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Middleware_Outcome(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		mw        notification.Middleware
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name: "short-circuit",
+			path: "/notification",
+			mw: func(next notification.SendFunc) notification.SendFunc {
+				return func(msg notification.Message, req *http.Request) (*http.Response, error) {
+					if msg == "skip" {
+						return &http.Response{
+							StatusCode: http.StatusAccepted,
+							Body:       http.NoBody,
+						}, nil
+					}
+					return next(msg, req)
+				}
+			},
+			wantCalls: 0,
+		},
+		{
+			name: "rewrite",
+			path: "/error-400",
+			mw: func(next notification.SendFunc) notification.SendFunc {
+				return func(msg notification.Message, req *http.Request) (*http.Response, error) {
+					resp, err := next(msg, req)
+					if err == nil && resp.StatusCode == http.StatusBadRequest {
+						resp.StatusCode = http.StatusOK
+					}
+					return resp, err
+				}
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "error",
+			path: "/notification",
+			mw: func(next notification.SendFunc) notification.SendFunc {
+				return func(msg notification.Message, req *http.Request) (*http.Response, error) {
+					return nil, errors.New("injected fault")
+				}
+			},
+			wantCalls: 0,
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mc := mocks.NewHTTPClient()
+			client := notification.NewClient(
+				server.URL+tc.path,
+				notification.WithHTTPClient(mc),
+				notification.WithMiddleware(tc.mw),
+			)
+			client.Start()
+
+			assert.Nil(t, client.Notify("skip"))
+
+			assert.Eventually(t, func() bool {
+				stats := client.Stats()
+				return stats.Delivered+stats.Failed == 1
+			}, 3*time.Second, 10*time.Millisecond)
+
+			assert.Equal(t, tc.wantCalls, mc.CallCount())
+			assert.Equal(t, tc.wantErr, client.Stats().Failed == 1)
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func TestClient_Middleware_ResponseAndError(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	// The middleware fails after the response
+	// was received.
+	var body *closeRecorder
+	mw := func(next notification.SendFunc) notification.SendFunc {
+		return func(msg notification.Message, req *http.Request) (*http.Response, error) {
+			resp, err := next(msg, req)
+			if err != nil {
+				return nil, err
+			}
+			body = &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			return resp, errors.New("injected fault")
+		}
+	}
+
+	client := notification.NewClient(
+		server.URL+"/notification",
+		notification.WithMiddleware(mw),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("hello"))
+	assert.NotNil(t, <-client.Errors())
+	assert.Nil(t, client.Stop())
+
+	if assert.NotNil(t, body) {
+		assert.True(t, body.closed)
+	}
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
+
+func TestClient_ResponseClassifier(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"ok":false,"retry":true}`))
+	}))
+	defer server.Close()
+
+	var gotBody []byte
+	classifier := func(resp notification.Response) notification.Verdict {
+		gotBody = resp.Body
+
+		var body struct {
+			OK    bool `json:"ok"`
+			Retry bool `json:"retry"`
+		}
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			return notification.DefaultClassifier(resp)
+		}
+
+		switch {
+		case body.OK:
+			return notification.Verdict{Outcome: notification.OutcomeSuccess}
+		case body.Retry:
+			return notification.Verdict{Outcome: notification.OutcomeRetryable, Reason: "receiver asked to retry"}
+		default:
+			return notification.Verdict{Outcome: notification.OutcomePermanent, Reason: "receiver rejected"}
+		}
+	}
+
+	client := notification.NewClient(
+		server.URL,
+		notification.WithResponseClassifier(classifier),
+		notification.WithResponseBodyLimit(10),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("hello"))
+
+	// The body is cut off at the limit, so the
+	// default classifier is used.
+	assert.Eventually(t, func() bool {
+		return client.Stats().Delivered == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Nil(t, client.Stop())
+	assert.Equal(t, `{"ok":fals`, string(gotBody))
+
+	client = notification.NewClient(
+		server.URL,
+		notification.WithResponseClassifier(classifier),
+	)
+	client.Start()
+
+	assert.Nil(t, client.Notify("hello"))
+
+	err := <-client.Errors()
+	var re interface {
+		requestError
+		Reason() string
+	}
+	if assert.True(t, errors.As(err, &re)) {
+		assert.True(t, re.IsRetryable())
+		assert.Equal(t, "receiver asked to retry", re.Reason())
+	}
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	tests := []struct {
+		status int
+		want   notification.Outcome
+	}{
+		{status: http.StatusOK, want: notification.OutcomeSuccess},
+		{status: http.StatusNoContent, want: notification.OutcomeSuccess},
+		{status: http.StatusMovedPermanently, want: notification.OutcomePermanent},
+		{status: http.StatusBadRequest, want: notification.OutcomePermanent},
+		{status: http.StatusBadGateway, want: notification.OutcomeRetryable},
+	}
+
+	for _, tc := range tests {
+		v := notification.DefaultClassifier(notification.Response{StatusCode: tc.status})
+		assert.Equal(t, tc.want, v.Outcome, tc.status)
+	}
+}
+
+func TestClient_ConnectionReuse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		opts      []notification.Opt
+		wantConns int64
+	}{
+		{
+			name:      "drain",
+			opts:      []notification.Opt{notification.WithResponseDrainLimit(1 << 20)},
+			wantConns: 1,
+		},
+		{
+			name:      "no drain",
+			opts:      []notification.Opt{notification.WithResponseDrainLimit(0)},
+			wantConns: 5,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, conns := largeBodyServer(t)
+			defer server.Close()
+
+			opts := append([]notification.Opt{
+				notification.WithMaxConcurrency(1),
+				notification.WithRateLimiter(fixedRateLimiter{}),
+			}, tc.opts...)
+			client := notification.NewClient(server.URL, opts...)
+			client.Start()
+
+			for i := 0; i < 5; i++ {
+				assert.Nil(t, client.Notify(fmt.Sprintf("msg%d", i)))
+			}
+
+			assert.Eventually(t, func() bool {
+				return client.Stats().Delivered == 5
+			}, 3*time.Second, 10*time.Millisecond)
+			assert.Equal(t, tc.wantConns, conns())
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func TestClient_ConnectionReuse_Concurrency(t *testing.T) {
+	t.Parallel()
+
+	// More workers than the default max concurrency.
+	const workers = 110
+
+	tests := []struct {
+		name        string
+		opts        []notification.Opt
+		concurrency int
+	}{
+		{
+			name: "option",
+			opts: []notification.Opt{notification.WithMaxConcurrency(workers)},
+		},
+		{
+			name:        "set",
+			opts:        []notification.Opt{notification.WithMaxConcurrency(1)},
+			concurrency: workers,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, conns := barrierServer(t, workers)
+			defer server.Close()
+
+			opts := append([]notification.Opt{
+				notification.WithRateLimiter(fixedRateLimiter{}),
+			}, tc.opts...)
+			client := notification.NewClient(server.URL, opts...)
+			if tc.concurrency > 0 {
+				assert.Nil(t, client.SetMaxConcurrency(tc.concurrency))
+			}
+			client.Start()
+
+			// Every round needs a connection per worker,
+			// which are all reused by the second round.
+			for round := 1; round <= 2; round++ {
+				for i := 0; i < workers; i++ {
+					assert.Nil(t, client.Notify(fmt.Sprintf("msg%d", i)))
+				}
+
+				assert.Eventually(t, func() bool {
+					return client.Stats().Delivered == uint64(round*workers)
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+			assert.Equal(t, int64(workers), conns())
+
+			assert.Nil(t, client.Stop())
+		})
+	}
+}
+
+func BenchmarkClient_Notify(b *testing.B) {
+	for _, bc := range []struct {
+		name       string
+		drainLimit int64
+	}{
+		{name: "drain", drainLimit: 1 << 20},
+		{name: "no drain", drainLimit: 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server, conns := largeBodyServer(b)
+			defer server.Close()
+
+			client := notification.NewClient(
+				server.URL,
+				notification.WithMaxConcurrency(10),
+				notification.WithMaxBufferSize(b.N),
+				notification.WithRateLimiter(fixedRateLimiter{}),
+				notification.WithResponseDrainLimit(bc.drainLimit),
+			)
+			client.Start()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := client.Notify("hello"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			for client.Stats().Delivered+client.Stats().Failed < uint64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+			if err := client.Stop(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(
+		server.URL+"/error-500",
+		notification.WithMaxRetries(1, 10*time.Millisecond),
+	)
+	all := client.Subscribe()
+	failed := client.Subscribe(notification.WithEventTypes(notification.EventFailed))
+	small := client.Subscribe(notification.WithEventBufferSize(1))
+	client.Start()
+
+	assert.Nil(t, client.NotifyWith("hello", notification.WithMessageID("id-1")))
+
+	var types []notification.EventType
+	for len(types) < 3 {
+		ev := <-all.Events()
+		assert.Equal(t, "hello", ev.Message)
+		assert.Equal(t, "id-1", ev.MessageID)
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, []notification.EventType{
+		notification.EventEnqueued,
+		notification.EventRetried,
+		notification.EventFailed,
+	}, types)
+
+	ev := <-failed.Events()
+	assert.Equal(t, notification.EventFailed, ev.Type)
+	assert.Equal(t, 2, ev.Attempt)
+	assert.Equal(t, "retries_exhausted", ev.Reason)
+	var re requestError
+	assert.True(t, errors.As(ev.Err, &re) && re.IsRetryable())
+
+	// Only the first event fits in the buffer.
+	assert.Eventually(t, func() bool {
+		return small.Dropped() == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(0), all.Dropped())
+
+	all.Close()
+	all.Close()
+	_, ok := <-all.Events()
+	assert.False(t, ok)
+
+	assert.Nil(t, client.Stop())
+
+	_, ok = <-failed.Events()
+	assert.False(t, ok)
+	_, ok = <-client.Subscribe().Events()
+	assert.False(t, ok)
+}
+
+func TestClient_Subscribe_Sent(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(server.URL + "/notification")
+	sub := client.Subscribe(notification.WithEventTypes(notification.EventSent))
+	client.Start()
+
+	assert.Nil(t, client.Notify("hello"))
+
+	ev := <-sub.Events()
+	assert.Equal(t, notification.EventSent, ev.Type)
+	assert.Equal(t, "sent", ev.Type.String())
+	assert.Equal(t, 1, ev.Attempt)
+	assert.Nil(t, ev.Err)
+	assert.False(t, ev.Time.IsZero())
+
+	assert.Nil(t, client.Stop())
+}
+
+func TestClient_Subscribe_NegativeBufferSize(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	client := notification.NewClient(server.URL + "/notification")
+
+	var sub *notification.Subscription
+	assert.NotPanics(t, func() {
+		sub = client.Subscribe(notification.WithEventBufferSize(-1))
+	})
+	client.Start()
+
+	// Nothing is buffered, so the event is dropped.
+	assert.Nil(t, client.Notify("hello"))
+	assert.Eventually(t, func() bool {
+		return sub.Dropped() > 0
+	}, time.Second, 10*time.Millisecond)
 
 	assert.Nil(t, client.Stop())
 }
@@ -161,6 +1623,19 @@ func testServer(t *testing.T) *httptest.Server {
 		return
 	})
 
+	// Closes the connection before the promised
+	// body was sent.
+	mux.HandleFunc("/truncated", func(w http.ResponseWriter, req *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = buf.WriteString("HTTP/1.1 201 Created\r\nContent-Length: 100\r\n\r\nshort")
+		_ = buf.Flush()
+	})
+
 	mux.HandleFunc("/long", func(w http.ResponseWriter, req *http.Request) {
 		<-time.After(3 * time.Second)
 		w.WriteHeader(http.StatusCreated)
@@ -170,6 +1645,29 @@ func testServer(t *testing.T) *httptest.Server {
 	return httptest.NewServer(mux)
 }
 
+// largeBodyServer returns a server that responds with a
+// body larger than what the transport drains on its own,
+// along with a func that returns the number of connections
+// opened.
+func largeBodyServer(tb testing.TB) (*httptest.Server, func() int64) {
+	tb.Helper()
+
+	body := bytes.Repeat([]byte("a"), 512<<10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(body)
+	}))
+
+	var conns int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+
+	return server, func() int64 { return atomic.LoadInt64(&conns) }
+}
+
 func assertChNoErrors(t *testing.T, ch <-chan error, d time.Duration) {
 	select {
 	case err := <-ch:
@@ -186,3 +1684,60 @@ type temporaryError interface {
 type requestError interface {
 	IsRetryable() bool
 }
+
+type expiredError interface {
+	IsExpired() bool
+	Message() string
+}
+
+type fixedRateLimiter struct{}
+
+func (fixedRateLimiter) Start()    {}
+func (fixedRateLimiter) Add() bool { return true }
+func (fixedRateLimiter) Stop()     {}
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	assert.Failf(t, "metric not found", name)
+	return 0
+}
+
+// barrierServer returns a server that holds requests until
+// n of them arrived, so that they are sent concurrently,
+// along with a func that returns the number of connections
+// opened.
+func barrierServer(tb testing.TB, n int64) (*httptest.Server, func() int64) {
+	tb.Helper()
+
+	var arrived int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Wait for the rest of the round.
+		target := (atomic.AddInt64(&arrived, 1) + n - 1) / n * n
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt64(&arrived) < target && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var conns int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+
+	return server, func() int64 { return atomic.LoadInt64(&conns) }
+}
@@ -3,6 +3,9 @@ package notification
 import (
 	"time"
 
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,9 +16,26 @@ const (
 	defaultLogLevel               = logrus.InfoLevel
 	defaultRateLimit              = 100
 	defaultRateLimitRetryDuration = 3 * time.Second
+	defaultRateLimitPollInterval  = 5 * time.Millisecond
+	defaultDrainPollInterval      = 10 * time.Millisecond
 	defaultConcurrency            = 100
+	defaultMaxScheduled           = 1000
+	defaultScheduleRetryDuration  = 100 * time.Millisecond
+	defaultRetryBackoff           = 100 * time.Millisecond
+	defaultDedupWindow            = 5 * time.Minute
+	defaultDedupMaxKeys           = 10000
+	maxRetryBackoff               = 30 * time.Second
+	defaultResponseBodyLimit      = 4096
+	defaultResponseDrainLimit     = 64 << 10
+	defaultIdleConnTimeout        = 90 * time.Second
 )
 
+// defaultSizeBuckets are the payload size histogram
+// buckets, from 64B to 1MiB.
+var defaultSizeBuckets = []float64{
+	64, 256, 1024, 4096, 16384, 65536, 262144, 1048576,
+}
+
 // config represents the configuration of the Notifier.
 type config struct {
 	// URL that requests will be sent to via
@@ -37,4 +57,67 @@ type config struct {
 	// maxConcurrency specifies the number of workers
 	// to pick up new messages.
 	maxConcurrency int
+
+	// maxRps specifies the max requests per second
+	// of the rate limiter.
+	//
+	// It is zero if a custom rate limiter is set.
+	maxRps uint64
+
+	// refill specifies the tokens the rate limiter is
+	// refilled with every 1/maxRps seconds.
+	refill uint64
+
+	// maxScheduled specifies the max number of messages
+	// that can be held for delivery at a later time.
+	maxScheduled int
+
+	// maxRetries specifies the number of times a
+	// message is sent again after a retryable failure.
+	maxRetries int
+
+	// retryBackoff specifies the time to wait before
+	// the first retry, which doubles for every retry
+	// up to maxRetryBackoff.
+	retryBackoff time.Duration
+
+	// defaultTTL specifies the time to live of messages
+	// that do not set their own.
+	//
+	// Messages never expire if it is zero.
+	defaultTTL time.Duration
+
+	// metrics configures metric collection.
+	metrics metricsConfig
+
+	// tracerProvider provides the tracer used to
+	// record spans.
+	tracerProvider trace.TracerProvider
+
+	// propagator injects the trace context into
+	// outgoing requests.
+	propagator propagation.TextMapPropagator
+
+	// classifier decides whether a message was
+	// delivered based on the response.
+	classifier ResponseClassifier
+
+	// responseBodyLimit is the max number of bytes of
+	// the response body passed to the classifier.
+	responseBodyLimit int64
+
+	// responseDrainLimit is the max number of bytes of
+	// the response body that are read and discarded so
+	// that the connection can be reused.
+	responseDrainLimit int64
+
+	// transport configures the default HTTP client.
+	transport transportConfig
+
+	// middleware wraps every attempt at sending.
+	middleware []Middleware
+
+	// earliestDeadlineFirst orders the message queue
+	// by message deadline instead of arrival.
+	earliestDeadlineFirst bool
 }
@@ -0,0 +1,127 @@
+package notification
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// DedupKey determines how messages are identified when
+// detecting duplicates.
+type DedupKey int
+
+const (
+	// DedupByID identifies messages by the ID set with
+	// WithMessageID.
+	//
+	// Messages without an ID fall back to being identified
+	// by their content.
+	DedupByID DedupKey = iota
+
+	// DedupByContent identifies messages by a hash of
+	// their content.
+	DedupByContent
+)
+
+// dedupEntry is a single key remembered by the deduper.
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// deduper remembers the keys of messages that were recently
+// accepted so that duplicates can be suppressed.
+//
+// Keys are forgotten once the window has elapsed or when
+// more than size keys are held, in which case the oldest
+// key is evicted first.
+//
+// It is safe for concurrent use.
+type deduper struct {
+	by     DedupKey
+	window time.Duration
+	size   int
+
+	// entries holds keys ordered from oldest to newest.
+	entries *list.List
+	keys    map[string]*list.Element
+
+	m   sync.Mutex
+	now func() time.Time
+}
+
+func newDeduper(by DedupKey, window time.Duration, size int) *deduper {
+	return &deduper{
+		by:      by,
+		window:  window,
+		size:    size,
+		entries: list.New(),
+		keys:    make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// key returns the key that identifies the message
+// wrapped by the envelope.
+func (d *deduper) key(e envelope) string {
+	if d.by == DedupByID && e.id != "" {
+		return "id:" + e.id
+	}
+
+	sum := sha256.Sum256([]byte(e.msg))
+	return "sha256:" + string(sum[:])
+}
+
+// add records the key of the envelope.
+//
+// It returns false if the key has already been seen
+// within the window, in which case the envelope is
+// a duplicate.
+func (d *deduper) add(e envelope) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	now := d.now()
+	d.evict(now)
+
+	k := d.key(e)
+	if _, ok := d.keys[k]; ok {
+		return false
+	}
+
+	d.keys[k] = d.entries.PushBack(dedupEntry{key: k, seen: now})
+	if d.entries.Len() > d.size {
+		d.removeElement(d.entries.Front())
+	}
+
+	return true
+}
+
+// remove forgets the key of the envelope.
+//
+// This is used when a message was recorded but could
+// not be enqueued, so that the caller may retry it.
+func (d *deduper) remove(e envelope) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if el, ok := d.keys[d.key(e)]; ok {
+		d.removeElement(el)
+	}
+}
+
+// evict removes all keys that are older than the window.
+func (d *deduper) evict(now time.Time) {
+	for el := d.entries.Front(); el != nil; el = d.entries.Front() {
+		if now.Sub(el.Value.(dedupEntry).seen) < d.window {
+			return
+		}
+		d.removeElement(el)
+	}
+}
+
+func (d *deduper) removeElement(el *list.Element) {
+	delete(d.keys, el.Value.(dedupEntry).key)
+	d.entries.Remove(el)
+}
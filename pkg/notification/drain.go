@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errStopped is returned when waiting on a Client
+// that was stopped.
+var errStopped = errors.New("client stopped")
+
+// Drain blocks until every message accepted so far was
+// delivered, failed, expired or was dropped.
+//
+// Scheduled messages are waited on until they are sent.
+// Messages may still be added while draining, in which
+// case they are waited on as well.
+//
+// A paused Client does not drain, so Drain blocks until
+// it is resumed or ctx is done. It returns the error of
+// ctx if it is done first, or an error if the Client is
+// stopped.
+func (c *Client) Drain(ctx context.Context) error {
+	t := time.NewTicker(defaultDrainPollInterval)
+	defer t.Stop()
+
+	for {
+		if c.stats.pendingMessages() == 0 {
+			return nil
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return errStopped
+		}
+	}
+}
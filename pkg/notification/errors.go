@@ -1,7 +1,14 @@
 package notification
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"syscall"
 	"time"
 )
 
@@ -17,14 +24,25 @@ import (
 
 type requestError struct {
 	err       error
+	status    int
+	reason    string
 	msg       Message
+	attempt   int
 	retryable bool
 }
 
-func newRequestError(status int, msg Message, retryable bool) error {
+func newRequestError(status int, msg Message, attempt int, retryable bool, reason string) error {
+	err := fmt.Errorf("request failed with status: %d", status)
+	if reason != "" {
+		err = fmt.Errorf("%w: %s", err, reason)
+	}
+
 	return requestError{
-		err:       fmt.Errorf("request failed with status: %d", status),
+		err:       err,
+		status:    status,
+		reason:    reason,
 		msg:       msg,
+		attempt:   attempt,
 		retryable: retryable,
 	}
 }
@@ -44,17 +62,34 @@ func (re requestError) Message() Message {
 	return re.msg
 }
 
+// StatusCode returns the status code of the response.
+func (re requestError) StatusCode() int {
+	return re.status
+}
+
+// Attempt returns the attempt number at which the
+// request failed, starting at 1.
+func (re requestError) Attempt() int {
+	return re.attempt
+}
+
+// Reason returns why the request was classified as failed.
+func (re requestError) Reason() string {
+	return re.reason
+}
+
 // enqueueError is the internal error type for
 // enqueuing messages.
 //
 // Callers should test errors for IsTemporary
 // and retry the call again.
 type enqueueError struct {
-	err error
+	err        error
+	retryAfter time.Duration
 }
 
-func newEnqueueError(err error) error {
-	return enqueueError{err: err}
+func newEnqueueError(err error, retryAfter time.Duration) error {
+	return enqueueError{err: err, retryAfter: retryAfter}
 }
 
 // Error implements the error interface.
@@ -69,6 +104,187 @@ func (er enqueueError) IsTemporary() bool {
 
 // RetryAfter returns the time duration after which enqueueing
 // can be tried again.
+//
+// It is estimated from the state of the Client at the
+// time the message was refused.
 func (er enqueueError) RetryAfter() time.Duration {
-	return defaultEnqueueRetryDuration
+	if er.retryAfter <= 0 {
+		return defaultEnqueueRetryDuration
+	}
+
+	return er.retryAfter
+}
+
+// expiredError is the internal error type returned
+// when a message is dropped because its deadline passed
+// before it could be sent.
+//
+// Callers should test errors for the IsExpired and
+// Message methods using errors.As.
+type expiredError struct {
+	msg      Message
+	deadline time.Time
+}
+
+func newExpiredError(msg Message, deadline time.Time) error {
+	return expiredError{msg: msg, deadline: deadline}
+}
+
+// Error implements the error interface.
+func (ee expiredError) Error() string {
+	return fmt.Sprintf(
+		"message expired at %s", ee.deadline.Format(time.RFC3339Nano),
+	)
+}
+
+// IsExpired returns if the message expired before being sent.
+func (ee expiredError) IsExpired() bool {
+	return true
+}
+
+// Message returns the message that expired.
+func (ee expiredError) Message() Message {
+	return ee.msg
+}
+
+// Deadline returns the time at which the message expired.
+func (ee expiredError) Deadline() time.Time {
+	return ee.deadline
+}
+
+// statusOf returns the status code carried by err,
+// or zero if there is none.
+func statusOf(err error) int {
+	var se interface{ StatusCode() int }
+	if errors.As(err, &se) {
+		return se.StatusCode()
+	}
+
+	return 0
+}
+
+// Kinds of transport errors.
+const (
+	transportKindTimeout  = "timeout"
+	transportKindRefused  = "refused"
+	transportKindReset    = "reset"
+	transportKindDNS      = "dns"
+	transportKindTLS      = "tls"
+	transportKindCanceled = "canceled"
+	transportKindUnknown  = "unknown"
+)
+
+// transportError is the internal error type returned
+// when a request could not be made or no response
+// was received.
+//
+// It encapsulates the underlying error, along with
+// the message that failed, the attempt number and if the
+// error can be retried or not.
+//
+// Callers should test errors for the IsRetryable, Message,
+// Attempt and Kind methods using errors.As.
+type transportError struct {
+	err       error
+	kind      string
+	msg       Message
+	attempt   int
+	retryable bool
+}
+
+// newTransportError classifies err by inspecting
+// the network error it wraps.
+//
+// Timeouts, refused and reset connections and temporary
+// DNS failures are retryable. Unknown hosts, TLS failures
+// and cancelled requests are not, nor are errors that
+// cannot be classified.
+func newTransportError(err error, msg Message, attempt int) error {
+	kind, retryable := classifyTransportError(err)
+
+	return transportError{
+		err:       err,
+		kind:      kind,
+		msg:       msg,
+		attempt:   attempt,
+		retryable: retryable,
+	}
+}
+
+func classifyTransportError(err error) (string, bool) {
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return transportKindCanceled, false
+	case isTLSError(err):
+		return transportKindTLS, false
+	case errors.As(err, &dnsErr):
+		return transportKindDNS, !dnsErr.IsNotFound
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return transportKindTimeout, true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return transportKindRefused, true
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return transportKindReset, true
+	default:
+		return transportKindUnknown, false
+	}
+}
+
+// isTLSError reports whether err was caused by a failed
+// TLS handshake or an invalid certificate.
+func isTLSError(err error) bool {
+	var (
+		authErr   x509.UnknownAuthorityError
+		certErr   x509.CertificateInvalidError
+		hostErr   x509.HostnameError
+		recordErr tls.RecordHeaderError
+	)
+
+	return errors.As(err, &authErr) ||
+		errors.As(err, &certErr) ||
+		errors.As(err, &hostErr) ||
+		errors.As(err, &recordErr)
+}
+
+// Error implements the error interface.
+func (te transportError) Error() string {
+	return fmt.Sprintf("send request: %s", te.err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (te transportError) Unwrap() error {
+	return te.err
+}
+
+// IsRetryable determines if an error can be retried.
+func (te transportError) IsRetryable() bool {
+	return te.retryable
+}
+
+// Message returns the message that failed when
+// a Notify attempt was made.
+func (te transportError) Message() Message {
+	return te.msg
+}
+
+// Attempt returns the attempt number at which the
+// request failed, starting at 1.
+func (te transportError) Attempt() int {
+	return te.attempt
+}
+
+// Kind returns the kind of failure, which is one of
+// "timeout", "refused", "reset", "dns", "tls",
+// "canceled" or "unknown".
+func (te transportError) Kind() string {
+	return te.kind
 }
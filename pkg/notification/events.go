@@ -0,0 +1,271 @@
+package notification
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultEventBufferSize = 100
+
+// EventType identifies what happened to a message.
+type EventType int
+
+const (
+	// EventEnqueued is published when a message is accepted,
+	// including messages scheduled for later delivery.
+	EventEnqueued EventType = iota + 1
+
+	// EventSent is published when a message was delivered.
+	EventSent
+
+	// EventRetried is published when an attempt at sending
+	// a message failed and the message will be sent again.
+	EventRetried
+
+	// EventFailed is published when a message could not
+	// be delivered.
+	EventFailed
+
+	// EventDropped is published when a message is given up
+	// on before it could be sent.
+	EventDropped
+
+	// EventExpired is published when a message is dropped
+	// because its deadline passed.
+	EventExpired
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventEnqueued:
+		return "enqueued"
+	case EventSent:
+		return "sent"
+	case EventRetried:
+		return "retried"
+	case EventFailed:
+		return "failed"
+	case EventDropped:
+		return "dropped"
+	case EventExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes an outcome in the delivery of a message.
+type Event struct {
+	// Type is the kind of event.
+	Type EventType
+
+	// Message is the message the event is about.
+	Message Message
+
+	// MessageID is the identifier of the message,
+	// if set with WithMessageID.
+	MessageID string
+
+	// Attempt is the number of the attempt at sending
+	// the message, starting at 1.
+	//
+	// It is zero for events published before the
+	// message was sent.
+	Attempt int
+
+	// Reason explains failed and dropped events, such as
+	// "permanent", "retries_exhausted", "rate_limited"
+	// or "stopped".
+	Reason string
+
+	// Err is the error that caused the event, if any.
+	Err error
+
+	// Time is the time at which the event occurred.
+	Time time.Time
+}
+
+// SubscribeOpt represents options that can be passed
+// to Client.Subscribe.
+type SubscribeOpt func(s *Subscription)
+
+// WithEventBufferSize sets the number of events that are
+// buffered for the subscriber.
+//
+// Events are dropped when the buffer is full. It is
+// set to 100 by default, and to zero if size is negative.
+func WithEventBufferSize(size int) SubscribeOpt {
+	return func(s *Subscription) {
+		if size < 0 {
+			size = 0
+		}
+		s.size = size
+	}
+}
+
+// WithEventTypes restricts the subscription to
+// the given types of events.
+//
+// All events are received by default.
+func WithEventTypes(types ...EventType) SubscribeOpt {
+	return func(s *Subscription) {
+		s.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+}
+
+// Subscription receives the events published by a Client.
+//
+// Events are never blocked on. If the subscriber does not
+// keep up, events are dropped and counted instead.
+type Subscription struct {
+	// dropped is accessed atomically and kept first
+	// to ensure 64-bit alignment.
+	dropped uint64
+
+	events chan Event
+	size   int
+	types  map[EventType]bool
+	hub    *eventHub
+}
+
+// Events returns the channel events are received on.
+//
+// The channel is closed when the subscription is closed
+// or the Client is stopped.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events that were dropped
+// because the buffer of the subscription was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the delivery of events and closes
+// the events channel.
+//
+// It is safe to call Close more than once.
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// wants reports whether the subscription receives
+// events of the given type.
+func (s *Subscription) wants(t EventType) bool {
+	return s.types == nil || s.types[t]
+}
+
+// eventHub fans out events to all subscriptions.
+//
+// It is safe for concurrent use.
+type eventHub struct {
+	subs   map[*Subscription]struct{}
+	closed bool
+	m      sync.RWMutex
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*Subscription]struct{})}
+}
+
+// add registers the subscription.
+//
+// Subscriptions added after the hub was closed
+// are closed immediately.
+func (h *eventHub) add(s *Subscription) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.closed {
+		close(s.events)
+		return
+	}
+	h.subs[s] = struct{}{}
+}
+
+// remove unregisters the subscription and closes
+// its events channel.
+func (h *eventHub) remove(s *Subscription) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.events)
+}
+
+// publish delivers the event to every subscription
+// that wants it, without blocking.
+func (h *eventHub) publish(ev Event) {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	for s := range h.subs {
+		if !s.wants(ev.Type) {
+			continue
+		}
+
+		select {
+		case s.events <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// close closes all subscriptions.
+func (h *eventHub) close() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.events)
+	}
+	h.closed = true
+}
+
+// Subscribe returns a subscription to the events
+// published for every message.
+//
+// Each subscription has its own buffer, so multiple
+// subscribers observe all events independently. Callers
+// should call Subscription.Close once done.
+//
+// Subscribing before Client.Start ensures that no
+// events are missed.
+func (c *Client) Subscribe(opts ...SubscribeOpt) *Subscription {
+	s := &Subscription{
+		size: defaultEventBufferSize,
+		hub:  c.events,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.events = make(chan Event, s.size)
+
+	c.events.add(s)
+
+	return s
+}
+
+// publish publishes an event for the envelope.
+func (c *Client) publish(t EventType, e envelope, attempt int, reason string, err error) {
+	c.events.publish(Event{
+		Type:      t,
+		Message:   e.msg,
+		MessageID: e.id,
+		Attempt:   attempt,
+		Reason:    reason,
+		Err:       err,
+		Time:      time.Now(),
+	})
+}
@@ -0,0 +1,281 @@
+// Package queue implements a bounded priority queue that hands
+// items to consumers over a channel.
+package queue
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// item wraps a value in the queue.
+type item[T any] struct {
+	v T
+
+	// seq is the insertion order of the item.
+	//
+	// It is used to keep items of equal priority
+	// in first in, first out order.
+	seq uint64
+
+	// index is the position of the item in the heap.
+	index int
+}
+
+// items is a heap of queued items.
+//
+// It implements heap.Interface.
+type items[T any] struct {
+	s    []*item[T]
+	less func(a, b T) bool
+}
+
+func (h *items[T]) Len() int { return len(h.s) }
+
+func (h *items[T]) Less(i, j int) bool {
+	a, b := h.s[i], h.s[j]
+	if h.less != nil {
+		if h.less(a.v, b.v) {
+			return true
+		}
+		if h.less(b.v, a.v) {
+			return false
+		}
+	}
+
+	return a.seq < b.seq
+}
+
+func (h *items[T]) Swap(i, j int) {
+	h.s[i], h.s[j] = h.s[j], h.s[i]
+	h.s[i].index = i
+	h.s[j].index = j
+}
+
+func (h *items[T]) Push(x interface{}) {
+	it := x.(*item[T])
+	it.index = len(h.s)
+	h.s = append(h.s, it)
+}
+
+func (h *items[T]) Pop() interface{} {
+	old := h.s
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	it.index = -1
+	h.s = old[:n-1]
+
+	return it
+}
+
+// Queue is a bounded queue of values.
+//
+// Values are handed out over the channel returned by Out,
+// from which any number of consumers can receive.
+//
+// By default, values are handed out in the order they were
+// pushed. If a less function is given, values that are less
+// than others are handed out first.
+//
+// Start must be called to begin handing out values and
+// Stop must be called to release resources.
+//
+// It is safe for concurrent use.
+type Queue[T any] struct {
+	items *items[T]
+
+	// capacity is the max number of values that
+	// the queue holds.
+	capacity int
+
+	// seq is the sequence number of the next item.
+	seq uint64
+
+	// out is the channel values are handed out on.
+	out chan T
+
+	// paused is set while values are not handed out.
+	paused bool
+
+	// changed is signalled whenever the queue changes
+	// so that the dispatcher can re-evaluate the head.
+	changed chan struct{}
+
+	m    sync.Mutex
+	stop chan struct{}
+}
+
+// New constructs a Queue that holds up to capacity values.
+//
+// less may be nil, in which case the queue is first in,
+// first out.
+func New[T any](capacity int, less func(a, b T) bool) *Queue[T] {
+	return &Queue[T]{
+		items:    &items[T]{less: less},
+		capacity: capacity,
+		out:      make(chan T),
+		changed:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Push adds a value to the queue.
+//
+// It never blocks. It returns false if the queue is full.
+func (q *Queue[T]) Push(v T) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.items.Len() >= q.capacity {
+		return false
+	}
+
+	heap.Push(q.items, &item[T]{v: v, seq: q.seq})
+	q.seq++
+	q.signal()
+
+	return true
+}
+
+// Out returns the channel on which values are handed out.
+//
+// The channel is never closed.
+func (q *Queue[T]) Out() <-chan T {
+	return q.out
+}
+
+// Len returns the number of values in the queue.
+func (q *Queue[T]) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return q.items.Len()
+}
+
+// Cap returns the capacity of the queue.
+func (q *Queue[T]) Cap() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return q.capacity
+}
+
+// SetCap changes the capacity of the queue.
+//
+// Values are never removed to fit the new capacity. If
+// the queue holds more values than the new capacity,
+// Push fails until enough values have been handed out.
+func (q *Queue[T]) SetCap(capacity int) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.capacity = capacity
+}
+
+// Pause stops handing out values.
+//
+// Values can still be pushed while the queue is paused.
+func (q *Queue[T]) Pause() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.paused = true
+	q.signal()
+}
+
+// Resume continues handing out values after Pause.
+func (q *Queue[T]) Resume() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.paused = false
+	q.signal()
+}
+
+// Paused returns if the queue is paused.
+func (q *Queue[T]) Paused() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return q.paused
+}
+
+// Start begins handing out values.
+//
+// Note that Stop must be called to gracefully exit.
+func (q *Queue[T]) Start() {
+	go q.dispatch()
+}
+
+// Stop stops handing out values.
+//
+// Values that are still queued remain in the queue.
+func (q *Queue[T]) Stop() {
+	close(q.stop)
+}
+
+// Drain removes and returns all values in the queue, in
+// the order they would have been handed out.
+//
+// It should only be called once consumers stopped
+// receiving from Out.
+func (q *Queue[T]) Drain() []T {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	vs := make([]T, 0, q.items.Len())
+	for q.items.Len() > 0 {
+		vs = append(vs, heap.Pop(q.items).(*item[T]).v)
+	}
+	q.signal()
+
+	return vs
+}
+
+// dispatch offers the head of the queue on the out channel
+// until a consumer receives it.
+//
+// If the queue changes while the head is on offer, the head
+// is re-evaluated as a higher priority value may have been
+// pushed.
+func (q *Queue[T]) dispatch() {
+	for {
+		var (
+			out  chan T
+			head *item[T]
+			v    T
+		)
+
+		q.m.Lock()
+		if q.items.Len() > 0 && !q.paused {
+			head = q.items.s[0]
+			out = q.out
+			v = head.v
+		}
+		q.m.Unlock()
+
+		select {
+		case out <- v:
+			q.m.Lock()
+			// The head is no longer in the heap
+			// if the queue was drained meanwhile.
+			if head.index >= 0 {
+				heap.Remove(q.items, head.index)
+			}
+			q.m.Unlock()
+		case <-q.changed:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// signal wakes up the dispatcher.
+//
+// It must be called with the lock held.
+func (q *Queue[T]) signal() {
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
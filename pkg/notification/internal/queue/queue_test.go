@@ -0,0 +1,133 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/pkg/notification/internal/queue"
+)
+
+func TestQueue_FIFO(t *testing.T) {
+	q := queue.New[int](10, nil)
+	for i := 0; i < 5; i++ {
+		assert.True(t, q.Push(i))
+	}
+
+	q.Start()
+	defer q.Stop()
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, i, receive(t, q))
+	}
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueue_Priority(t *testing.T) {
+	q := queue.New[int](10, func(a, b int) bool { return a < b })
+	for _, v := range []int{3, 1, 2, 1} {
+		assert.True(t, q.Push(v))
+	}
+
+	q.Start()
+	defer q.Stop()
+
+	for _, want := range []int{1, 1, 2, 3} {
+		assert.Equal(t, want, receive(t, q))
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := queue.New[int](2, nil)
+
+	assert.True(t, q.Push(1))
+	assert.True(t, q.Push(2))
+	assert.False(t, q.Push(3))
+
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, 2, q.Cap())
+}
+
+func TestQueue_SetCap(t *testing.T) {
+	q := queue.New[int](3, nil)
+	for i := 0; i < 3; i++ {
+		assert.True(t, q.Push(i))
+	}
+
+	q.SetCap(1)
+	assert.Equal(t, 3, q.Len())
+	assert.False(t, q.Push(3))
+
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal(t, 0, receive(t, q))
+	assert.Equal(t, 1, receive(t, q))
+	assert.Equal(t, 2, receive(t, q))
+	assert.True(t, q.Push(3))
+
+	q.SetCap(5)
+	assert.Equal(t, 5, q.Cap())
+}
+
+func TestQueue_Pause(t *testing.T) {
+	q := queue.New[int](2, nil)
+	q.Start()
+	defer q.Stop()
+
+	q.Pause()
+	assert.True(t, q.Paused())
+	assert.True(t, q.Push(1))
+
+	select {
+	case <-q.Out():
+		assert.Fail(t, "expected no values while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	q.Resume()
+	assert.False(t, q.Paused())
+	assert.Equal(t, 1, receive(t, q))
+}
+
+func TestQueue_PushAfterStart(t *testing.T) {
+	q := queue.New[int](2, nil)
+	q.Start()
+	defer q.Stop()
+
+	select {
+	case <-q.Out():
+		assert.Fail(t, "expected no values")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.True(t, q.Push(1))
+	assert.Equal(t, 1, receive(t, q))
+}
+
+func receive(t *testing.T, q *queue.Queue[int]) int {
+	t.Helper()
+
+	select {
+	case v := <-q.Out():
+		return v
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "timed out waiting for value")
+		return -1
+	}
+}
+
+func TestQueue_Drain(t *testing.T) {
+	q := queue.New[int](3, func(a, b int) bool { return a < b })
+	q.Start()
+
+	assert.True(t, q.Push(3))
+	assert.True(t, q.Push(1))
+	assert.True(t, q.Push(2))
+
+	q.Stop()
+	assert.Equal(t, []int{1, 2, 3}, q.Drain())
+	assert.Equal(t, 0, q.Len())
+	assert.Empty(t, q.Drain())
+}
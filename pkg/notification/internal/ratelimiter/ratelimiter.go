@@ -31,6 +31,10 @@ type RateLimiter struct {
 	// tokens are refilled.
 	refillEvery time.Duration
 
+	// reset is signalled when the refill duration
+	// changes so that the ticker can be reset.
+	reset chan struct{}
+
 	m    sync.Mutex
 	stop chan struct{}
 }
@@ -44,7 +48,8 @@ func New(rps uint64, refill uint64) *RateLimiter {
 		tokens:       rps,
 		max:          rps,
 		refillTokens: refill,
-		refillEvery:  time.Duration(float64(time.Second) / float64(rps)),
+		refillEvery:  refillEvery(rps),
+		reset:        make(chan struct{}, 1),
 		stop:         make(chan struct{}),
 	}
 
@@ -61,8 +66,12 @@ func New(rps uint64, refill uint64) *RateLimiter {
 //
 // Note that Stop must be called to gracefully exit.
 func (r *RateLimiter) Start() {
+	r.m.Lock()
+	every := r.refillEvery
+	r.m.Unlock()
+
 	go func() {
-		t := time.NewTicker(r.refillEvery)
+		t := time.NewTicker(every)
 		defer t.Stop()
 
 		for {
@@ -75,6 +84,10 @@ func (r *RateLimiter) Start() {
 				}
 				r.tokens = t
 				r.m.Unlock()
+			case <-r.reset:
+				r.m.Lock()
+				t.Reset(r.refillEvery)
+				r.m.Unlock()
 			case <-r.stop:
 				return
 			}
@@ -99,7 +112,43 @@ func (r *RateLimiter) Add() bool {
 	return false
 }
 
+// Tokens returns the number of tokens currently available.
+func (r *RateLimiter) Tokens() uint64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.tokens
+}
+
+// SetRate changes the max requests allowed per second along
+// with the number of tokens the rate limiter is refilled with.
+//
+// It is safe to call while the rate limiter is running.
+// Tokens above the new max are discarded.
+func (r *RateLimiter) SetRate(rps uint64, refill uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.max = rps
+	r.refillTokens = refill
+	r.refillEvery = refillEvery(rps)
+	if r.tokens > r.max {
+		r.tokens = r.max
+	}
+
+	select {
+	case r.reset <- struct{}{}:
+	default:
+	}
+}
+
 // Stop gracefully stops the rate limiter.
 func (r *RateLimiter) Stop() {
 	close(r.stop)
 }
+
+// refillEvery returns the duration after which tokens
+// are refilled for the given rps.
+func refillEvery(rps uint64) time.Duration {
+	return time.Duration(float64(time.Second) / float64(rps))
+}
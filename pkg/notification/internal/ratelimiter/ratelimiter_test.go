@@ -38,3 +38,26 @@ func TestRateLimiter_RefreshToken(t *testing.T) {
 	<-time.After(2 * time.Second)
 	assert.True(t, r.Add())
 }
+
+func TestRateLimiter_Tokens(t *testing.T) {
+	r := ratelimiter.New(2, 0)
+
+	assert.Equal(t, uint64(2), r.Tokens())
+	assert.True(t, r.Add())
+	assert.Equal(t, uint64(1), r.Tokens())
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	r := ratelimiter.New(1, 0)
+	r.Start()
+	defer r.Stop()
+
+	assert.True(t, r.Add())
+	assert.False(t, r.Add())
+
+	r.SetRate(10, 10)
+
+	<-time.After(200 * time.Millisecond)
+	assert.True(t, r.Add())
+	assert.True(t, r.Add())
+}
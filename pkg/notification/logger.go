@@ -0,0 +1,103 @@
+package notification
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Keys of the fields logged by the Client.
+//
+// They are used consistently across all log lines
+// so that logs can be filtered by them.
+const (
+	logKeyWorker    = "worker_num"
+	logKeyMessage   = "msg_body"
+	logKeyMessageID = "msg_id"
+	logKeyStatus    = "status"
+	logKeyAttempt   = "attempt"
+	logKeyError     = "error"
+)
+
+// Logger is the structured logger used by the Client.
+//
+// Fields are passed as alternating keys and values, in the
+// same way as log/slog and the sugared zap logger.
+//
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NewLogrusLogger returns a Logger that writes to
+// the given logrus logger.
+func NewLogrusLogger(l *logrus.Logger) Logger {
+	return logrusLogger{l: l}
+}
+
+// NopLogger returns a Logger that discards all logs.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+// logrusLogger adapts a logrus logger to Logger.
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+func (l logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Info(msg)
+}
+
+func (l logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Error(msg)
+}
+
+// entry converts keys and values to logrus fields.
+//
+// A key without a value is logged with a nil value.
+func (l logrusLogger) entry(keysAndValues []interface{}) *logrus.Entry {
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = "!BADKEY"
+		}
+
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fields[key] = value
+	}
+
+	return l.l.WithFields(fields)
+}
+
+// nopLogger is used when logging is disabled.
+type nopLogger struct{}
+
+func (nopLogger) Debug(_ string, _ ...interface{}) {}
+func (nopLogger) Info(_ string, _ ...interface{})  {}
+func (nopLogger) Error(_ string, _ ...interface{}) {}
+
+// newLogger creates and configures a logger.
+//
+// By default, it uses the info log level.
+// It always logs in a structured format.
+func newLogger(enabled bool, level logrus.Level) Logger {
+	// Discard log output if disabled.
+	if !enabled {
+		return nopLogger{}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(level)
+	logger.SetFormatter(&logrus.TextFormatter{})
+
+	return NewLogrusLogger(logger)
+}
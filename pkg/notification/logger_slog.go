@@ -0,0 +1,31 @@
+//go:build go1.21
+
+package notification
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger returns a Logger that writes to
+// the given slog logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+// slogLogger adapts a slog logger to Logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelDebug, msg, keysAndValues...)
+}
+
+func (l slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelInfo, msg, keysAndValues...)
+}
+
+func (l slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelError, msg, keysAndValues...)
+}
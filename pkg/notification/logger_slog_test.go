@@ -0,0 +1,83 @@
+//go:build go1.21
+
+package notification_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+func TestSlogLogger_ClientFields(t *testing.T) {
+	t.Parallel()
+
+	server := testServer(t)
+	defer server.Close()
+
+	buf := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	client := notification.NewClient(
+		server.URL+"/error-400",
+		notification.WithLogger(notification.NewSlogLogger(logger)),
+		notification.WithMaxConcurrency(1),
+	)
+	client.Start()
+
+	err := client.NotifyWith("hello", notification.WithMessageID("id-1"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return client.Stats().Failed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Nil(t, client.Stop())
+
+	var failed map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+
+		if line["msg"] == "failed to send notification" {
+			failed = line
+		}
+	}
+
+	if assert.NotNil(t, failed) {
+		assert.Equal(t, "ERROR", failed["level"])
+		assert.Equal(t, 0.0, failed["worker_num"])
+		assert.Equal(t, "id-1", failed["msg_id"])
+		assert.Equal(t, 400.0, failed["status"])
+		assert.Equal(t, 1.0, failed["attempt"])
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	buf bytes.Buffer
+	m   sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -0,0 +1,63 @@
+package notification_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vivangkumar/notify/pkg/notification"
+)
+
+func TestLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.SetLevel(logrus.InfoLevel)
+
+	logger := notification.NewLogrusLogger(l)
+	logger.Debug("hidden")
+	logger.Error("failed", "worker_num", 1, "status", 500, "dangling")
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+
+	assert.Equal(t, "failed", line["msg"])
+	assert.Equal(t, "error", line["level"])
+	assert.Equal(t, 1.0, line["worker_num"])
+	assert.Equal(t, 500.0, line["status"])
+	assert.Contains(t, line, "dangling")
+}
+
+func TestNopLogger(t *testing.T) {
+	logger := notification.NopLogger()
+
+	assert.NotPanics(t, func() {
+		logger.Debug("msg", "key", "value")
+		logger.Info("msg")
+		logger.Error("msg", "key")
+	})
+}
+
+func TestWithLogger_Nil(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := notification.NewClient(server.URL, notification.WithLogger(nil))
+
+	assert.NotPanics(t, func() {
+		client.Start()
+		assert.Nil(t, client.Notify("hello"))
+		assert.Nil(t, client.Drain(context.Background()))
+		assert.Nil(t, client.Stop())
+	})
+}
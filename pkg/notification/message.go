@@ -1,4 +1,221 @@
 package notification
 
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
 // Message is an alias for string.
 type Message = string
+
+// envelope wraps a Message with the metadata the Client
+// needs to deliver it.
+//
+// Envelopes are internal to the Client; callers set
+// metadata using MessageOpt values.
+type envelope struct {
+	// msg is the message that is sent as the request body.
+	msg Message
+
+	// id is the caller supplied identifier of the message.
+	//
+	// It is empty unless set with WithMessageID.
+	id string
+
+	// sendAt is the time at which the message is due
+	// to be sent.
+	//
+	// The message is sent as soon as possible if it
+	// is the zero time.
+	sendAt time.Time
+
+	// deadline is the time after which the message
+	// is no longer worth sending.
+	//
+	// The message never expires if it is the zero time.
+	deadline time.Time
+
+	// ttl is the time to live of the message, from which
+	// the deadline is derived when the message is queued.
+	ttl time.Duration
+
+	// priority determines the order in which queued
+	// messages are sent, highest first.
+	priority int
+
+	// header holds additional headers that are sent
+	// with the request.
+	header http.Header
+
+	// queuedAt is the time at which the message
+	// was added to the message queue.
+	queuedAt time.Time
+
+	// ctx is the context the message was submitted with.
+	//
+	// It is only used to capture the trace context of
+	// the caller and is nil if not set.
+	ctx context.Context
+
+	// spanCtx is the span context under which the spans
+	// for the delivery of the message are recorded.
+	spanCtx trace.SpanContext
+}
+
+// due returns the time at which the message is to be sent,
+// which is now unless it is scheduled for later.
+func (e envelope) due(now time.Time) time.Time {
+	if e.sendAt.After(now) {
+		return e.sendAt
+	}
+
+	return now
+}
+
+// expired reports whether the deadline of the message has passed.
+func (e envelope) expired(now time.Time) bool {
+	return !e.deadline.IsZero() && now.After(e.deadline)
+}
+
+// earlierDeadline orders envelopes by their deadline.
+//
+// Envelopes without a deadline are ordered last.
+func earlierDeadline(a, b envelope) bool {
+	switch {
+	case a.deadline.IsZero():
+		return false
+	case b.deadline.IsZero():
+		return true
+	default:
+		return a.deadline.Before(b.deadline)
+	}
+}
+
+// byPriority orders envelopes by their priority, highest
+// first, and then by next if it is not nil.
+func byPriority(next func(a, b envelope) bool) func(a, b envelope) bool {
+	return func(a, b envelope) bool {
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if next != nil {
+			return next(a, b)
+		}
+
+		return false
+	}
+}
+
+// MessageOpt represents options that can be passed along
+// with a single message to configure its delivery.
+type MessageOpt func(e *envelope)
+
+// WithMessageID sets the identifier of a message.
+//
+// The identifier is used to detect duplicate messages
+// when deduplication is enabled with WithDeduplication.
+func WithMessageID(id string) MessageOpt {
+	return func(e *envelope) {
+		e.id = id
+	}
+}
+
+// WithSendAt schedules a message to be sent at the given time.
+//
+// The message is held by the Client until it is due, after
+// which it is queued like any other message. Messages due
+// in the past are queued immediately.
+func WithSendAt(t time.Time) MessageOpt {
+	return func(e *envelope) {
+		e.sendAt = t
+	}
+}
+
+// WithDelay schedules a message to be sent after the
+// given duration has elapsed.
+//
+// See WithSendAt.
+func WithDelay(d time.Duration) MessageOpt {
+	return func(e *envelope) {
+		e.sendAt = time.Now().Add(d)
+	}
+}
+
+// WithTTL sets the time to live of a message.
+//
+// The message is dropped instead of sent if it is still
+// waiting to be sent once the duration has elapsed. For
+// scheduled messages, the duration starts when the
+// message is due.
+//
+// It overrides the default set with WithDefaultTTL.
+func WithTTL(d time.Duration) MessageOpt {
+	return func(e *envelope) {
+		e.ttl = d
+	}
+}
+
+// WithDeadline sets the time after which a message is
+// dropped instead of sent.
+//
+// It overrides the default set with WithDefaultTTL.
+func WithDeadline(t time.Time) MessageOpt {
+	return func(e *envelope) {
+		e.deadline = t
+	}
+}
+
+// WithPriority sets the priority of a message.
+//
+// Queued messages with a higher priority are sent before
+// those with a lower one. Messages of equal priority are
+// sent in the order they were queued in, or by deadline
+// with WithEarliestDeadlineFirst. The priority is zero
+// by default.
+func WithPriority(p int) MessageOpt {
+	return func(e *envelope) {
+		e.priority = p
+	}
+}
+
+// WithHeader adds a header that is sent along with
+// the request for a message.
+//
+// It can be given more than once to add multiple headers.
+func WithHeader(key, value string) MessageOpt {
+	return func(e *envelope) {
+		if e.header == nil {
+			e.header = make(http.Header)
+		}
+		e.header.Add(key, value)
+	}
+}
+
+// WithContext sets the context the message is submitted with.
+//
+// The context is used to link the spans recorded for the
+// delivery of the message to the trace of the caller. It
+// does not control cancellation of the delivery.
+func WithContext(ctx context.Context) MessageOpt {
+	return func(e *envelope) {
+		e.ctx = ctx
+	}
+}
+
+func newEnvelope(msg Message, opts ...MessageOpt) envelope {
+	e := envelope{msg: msg}
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	return e
+}
+
+// logFields returns the fields that identify the
+// message in log lines.
+func (e envelope) logFields() []interface{} {
+	return []interface{}{logKeyMessageID, e.id, logKeyMessage, e.msg}
+}
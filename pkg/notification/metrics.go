@@ -1,14 +1,42 @@
 package notification
 
 import (
-	"github.com/prometheus/client_golang/prometheus"
+	"fmt"
+	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for which messages fail or are dropped.
+//
+// These are used as metric label values.
+const (
+	failReasonPermanent        = "permanent"
+	failReasonRetriesExhausted = "retries_exhausted"
+
+	dropReasonExpired     = "expired"
+	dropReasonRateLimited = "rate_limited"
+	dropReasonStopped     = "stopped"
 )
 
 type metrics interface {
 	setClientMaxBufferSize(size int)
+	setMaxConcurrency(n int)
+	setMaxRps(rps uint64)
+	setPaused(paused bool)
 	incrEnqueueFailures()
+	incrDuplicates()
+	incrSent()
+	incrFailed(reason string)
+	incrDropped(reason string)
+	incrRetries()
+	measureRateLimitWait(start time.Time)
+	measureDeliveryLatency(queuedAt time.Time)
+	observePayloadSize(size int)
 	measureHTTPLatency(start time.Time, status string)
+	incrConnections(reused bool)
 	registry() *prometheus.Registry
 }
 
@@ -16,10 +44,53 @@ type metrics interface {
 type noopMetrics struct{}
 
 func (n noopMetrics) setClientMaxBufferSize(_ int)             {}
+func (n noopMetrics) setMaxConcurrency(_ int)                  {}
+func (n noopMetrics) setMaxRps(_ uint64)                       {}
+func (n noopMetrics) setPaused(_ bool)                         {}
 func (n noopMetrics) incrEnqueueFailures()                     {}
+func (n noopMetrics) incrDuplicates()                          {}
+func (n noopMetrics) incrSent()                                {}
+func (n noopMetrics) incrFailed(_ string)                      {}
+func (n noopMetrics) incrDropped(_ string)                     {}
+func (n noopMetrics) incrRetries()                             {}
+func (n noopMetrics) measureRateLimitWait(_ time.Time)         {}
+func (n noopMetrics) measureDeliveryLatency(_ time.Time)       {}
+func (n noopMetrics) observePayloadSize(_ int)                 {}
 func (n noopMetrics) measureHTTPLatency(_ time.Time, _ string) {}
+func (n noopMetrics) incrConnections(_ bool)                   {}
 func (n noopMetrics) registry() *prometheus.Registry           { return nil }
 
+// metricsConfig configures the metrics of a Client.
+type metricsConfig struct {
+	// enabled turns on metric collection.
+	enabled bool
+
+	// reg is the registry metrics are registered with.
+	reg *prometheus.Registry
+
+	// namespace is prefixed to all metric names.
+	namespace string
+
+	// labels are added to all metrics.
+	labels prometheus.Labels
+
+	// latencyBuckets are the buckets of all latency
+	// histograms, in seconds.
+	latencyBuckets []float64
+
+	// sizeBuckets are the buckets of the payload
+	// size histogram, in bytes.
+	sizeBuckets []float64
+}
+
+// metricSources reports state that is read when
+// metrics are collected.
+type metricSources struct {
+	queueDepth func() float64
+	scheduled  func() float64
+	inFlight   func() float64
+}
+
 // clientMetrics contains a collection of prometheus metrics
 // that can be reported if required.
 type clientMetrics struct {
@@ -31,10 +102,63 @@ type clientMetrics struct {
 	// size of the client buffer.
 	maxBufferSize prometheus.Gauge
 
+	// maxConcurrency reports the currently set
+	// number of workers.
+	maxConcurrency prometheus.Gauge
+
+	// maxRps reports the currently set rps
+	// of the rate limiter.
+	maxRps prometheus.Gauge
+
+	// paused reports 1 while the client is paused
+	// and 0 otherwise.
+	paused prometheus.Gauge
+
+	// queueDepth, scheduled and inFlight report the
+	// number of queued messages, scheduled messages
+	// and requests being made respectively.
+	queueDepth prometheus.GaugeFunc
+	scheduled  prometheus.GaugeFunc
+	inFlight   prometheus.GaugeFunc
+
 	// enqueueFailures returns the number of
 	// failures when attempting to queue messages.
 	enqueueFailures prometheus.Counter
 
+	// duplicates reports the number of messages
+	// suppressed as duplicates.
+	duplicates prometheus.Counter
+
+	// sent, failed and dropped report the number of
+	// messages that were sent, that could not be sent
+	// and that were never attempted respectively.
+	//
+	// failed and dropped are partitioned by reason.
+	sent    prometheus.Counter
+	failed  *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+
+	// expired reports the number of messages
+	// dropped because their deadline passed.
+	//
+	// They are counted as dropped as well.
+	expired prometheus.Counter
+
+	// retries reports the number of times messages
+	// were sent again after a retryable failure.
+	retries prometheus.Counter
+
+	// rateLimitWait reports the time spent waiting
+	// for the rate limiter.
+	rateLimitWait prometheus.Histogram
+
+	// deliveryLatency reports the time from a message
+	// being queued until it was sent.
+	deliveryLatency prometheus.Histogram
+
+	// payloadSize reports the size of sent messages.
+	payloadSize prometheus.Histogram
+
 	// httpRequestLatency reports the request latency
 	// of notification HTTP requests.
 	//
@@ -43,33 +167,171 @@ type clientMetrics struct {
 	// Histograms can be used to observe counts of
 	// requests and errors (by status code).
 	httpRequestLatency *prometheus.HistogramVec
+
+	// connections reports the number of connections
+	// requests were sent over.
+	//
+	// Partitioned by whether the connection was reused.
+	connections *prometheus.CounterVec
 }
 
-func newMetrics(enabled bool, r *prometheus.Registry) metrics {
-	if !enabled {
+func newMetrics(cfg metricsConfig, src metricSources) metrics {
+	if !cfg.enabled {
 		return noopMetrics{}
 	}
 
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.labels,
+		})
+	}
+	gaugeFunc := func(name, help string, fn func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.labels,
+		}, fn)
+	}
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.labels,
+		})
+	}
+	counterVec := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.labels,
+		}, labels)
+	}
+	histogramOpts := func(name, help string, buckets []float64) prometheus.HistogramOpts {
+		return prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.labels,
+			Buckets:     buckets,
+		}
+	}
+
 	m := &clientMetrics{
-		reg: r,
-		maxBufferSize: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "max_buffer_size",
-			Help: "Reports the configured max buffer size.",
-		}),
-		enqueueFailures: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "notify_timeout_total",
-			Help: "Reports the total number of failures when attempting to queue messages.",
-		}),
-		httpRequestLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Name: "http_request_latency_duration_seconds",
-			Help: "Reports the latency of notification HTTP requests.",
-		}, []string{"status"}),
+		reg: cfg.reg,
+		maxBufferSize: gauge(
+			"max_buffer_size",
+			"Reports the configured max buffer size.",
+		),
+		maxConcurrency: gauge(
+			"max_concurrency",
+			"Reports the configured number of workers.",
+		),
+		maxRps: gauge(
+			"max_rps",
+			"Reports the configured max requests per second.",
+		),
+		paused: gauge(
+			"paused",
+			"Reports if the client is paused.",
+		),
+		queueDepth: gaugeFunc(
+			"queue_depth",
+			"Reports the number of messages waiting to be sent.",
+			src.queueDepth,
+		),
+		scheduled: gaugeFunc(
+			"scheduled_messages",
+			"Reports the number of messages scheduled for later delivery.",
+			src.scheduled,
+		),
+		inFlight: gaugeFunc(
+			"in_flight_requests",
+			"Reports the number of notification HTTP requests being made.",
+			src.inFlight,
+		),
+		enqueueFailures: counter(
+			"notify_timeout_total",
+			"Reports the total number of failures when attempting to queue messages.",
+		),
+		duplicates: counter(
+			"duplicates_suppressed_total",
+			"Reports the total number of duplicate messages that were suppressed.",
+		),
+		sent: counter(
+			"messages_sent_total",
+			"Reports the total number of messages sent successfully.",
+		),
+		failed: counterVec(
+			"messages_failed_total",
+			"Reports the total number of messages that could not be sent.",
+			"reason",
+		),
+		dropped: counterVec(
+			"messages_dropped_total",
+			"Reports the total number of queued messages that were never sent.",
+			"reason",
+		),
+		expired: counter(
+			"messages_expired_total",
+			"Reports the total number of messages dropped because their deadline passed.",
+		),
+		retries: counter(
+			"retries_total",
+			"Reports the total number of times messages were sent again.",
+		),
+		rateLimitWait: prometheus.NewHistogram(histogramOpts(
+			"rate_limit_wait_duration_seconds",
+			"Reports the time spent waiting for the rate limiter.",
+			cfg.latencyBuckets,
+		)),
+		deliveryLatency: prometheus.NewHistogram(histogramOpts(
+			"delivery_latency_duration_seconds",
+			"Reports the time from a message being queued until it was sent.",
+			cfg.latencyBuckets,
+		)),
+		payloadSize: prometheus.NewHistogram(histogramOpts(
+			"payload_size_bytes",
+			"Reports the size of sent messages.",
+			cfg.sizeBuckets,
+		)),
+		httpRequestLatency: prometheus.NewHistogramVec(histogramOpts(
+			"http_request_latency_duration_seconds",
+			"Reports the latency of notification HTTP requests.",
+			cfg.latencyBuckets,
+		), []string{"status"}),
+		connections: counterVec(
+			"connections_total",
+			"Reports the total number of connections requests were sent over.",
+			"reused",
+		),
 	}
 
 	m.reg.MustRegister(
 		m.maxBufferSize,
+		m.maxConcurrency,
+		m.maxRps,
+		m.paused,
+		m.queueDepth,
+		m.scheduled,
+		m.inFlight,
 		m.enqueueFailures,
+		m.duplicates,
+		m.sent,
+		m.failed,
+		m.dropped,
+		m.expired,
+		m.retries,
+		m.rateLimitWait,
+		m.deliveryLatency,
+		m.payloadSize,
 		m.httpRequestLatency,
+		m.connections,
 	)
 
 	return m
@@ -79,16 +341,83 @@ func (m *clientMetrics) setClientMaxBufferSize(cn int) {
 	m.maxBufferSize.Set(float64(cn))
 }
 
+func (m *clientMetrics) setMaxConcurrency(n int) {
+	m.maxConcurrency.Set(float64(n))
+}
+
+func (m *clientMetrics) setMaxRps(rps uint64) {
+	m.maxRps.Set(float64(rps))
+}
+
+func (m *clientMetrics) setPaused(paused bool) {
+	if paused {
+		m.paused.Set(1)
+		return
+	}
+	m.paused.Set(0)
+}
+
 func (m *clientMetrics) incrEnqueueFailures() {
 	m.enqueueFailures.Inc()
 }
 
+func (m *clientMetrics) incrDuplicates() {
+	m.duplicates.Inc()
+}
+
+func (m *clientMetrics) incrSent() {
+	m.sent.Inc()
+}
+
+func (m *clientMetrics) incrFailed(reason string) {
+	m.failed.WithLabelValues(reason).Inc()
+}
+
+func (m *clientMetrics) incrDropped(reason string) {
+	m.dropped.WithLabelValues(reason).Inc()
+	if reason == dropReasonExpired {
+		m.expired.Inc()
+	}
+}
+
+func (m *clientMetrics) incrRetries() {
+	m.retries.Inc()
+}
+
+func (m *clientMetrics) measureRateLimitWait(start time.Time) {
+	m.rateLimitWait.Observe(time.Since(start).Seconds())
+}
+
+func (m *clientMetrics) measureDeliveryLatency(queuedAt time.Time) {
+	m.deliveryLatency.Observe(time.Since(queuedAt).Seconds())
+}
+
+func (m *clientMetrics) observePayloadSize(size int) {
+	m.payloadSize.Observe(float64(size))
+}
+
 func (m *clientMetrics) measureHTTPLatency(start time.Time, status string) {
 	m.httpRequestLatency.
 		WithLabelValues(status).
 		Observe(time.Since(start).Seconds())
 }
 
+// statusLabel returns the status of the response as it
+// is labelled in the request latency, such as "200 OK".
+//
+// Responses made up by a middleware may not set it.
+func statusLabel(resp *http.Response) string {
+	if resp.Status != "" {
+		return resp.Status
+	}
+
+	return fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+}
+
+func (m *clientMetrics) incrConnections(reused bool) {
+	m.connections.WithLabelValues(strconv.FormatBool(reused)).Inc()
+}
+
 func (m *clientMetrics) registry() *prometheus.Registry {
 	return m.reg
 }
@@ -0,0 +1,38 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+)
+
+// SendFunc performs a single attempt at sending a message
+// with the given request.
+//
+// The request body holds the message. The response body
+// is closed by the Client.
+type SendFunc func(msg Message, req *http.Request) (*http.Response, error)
+
+// Middleware wraps a SendFunc with behaviour that runs around
+// sending a message, such as adding headers or signing requests.
+//
+// A middleware can inspect and modify the request before
+// calling next, and inspect and replace the response or
+// error it returns. It can also short-circuit sending by
+// returning without calling next.
+type Middleware func(next SendFunc) SendFunc
+
+// errNoResponse is returned when a middleware returns
+// neither a response nor an error.
+var errNoResponse = errors.New("middleware returned no response")
+
+// chain wraps send with the middleware.
+//
+// The first middleware is the outermost, so it runs first
+// before sending and last after.
+func chain(send SendFunc, mw []Middleware) SendFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		send = mw[i](send)
+	}
+
+	return send
+}
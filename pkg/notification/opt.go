@@ -6,6 +6,8 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/vivangkumar/notify/pkg/notification/internal/ratelimiter"
 )
@@ -20,6 +22,12 @@ type rateLimiter interface {
 	Stop()
 }
 
+// adjustableRateLimiter is a rate limiter whose rate
+// can be changed while it is running.
+type adjustableRateLimiter interface {
+	SetRate(rps uint64, refill uint64)
+}
+
 // Opt represents options that can be passed to the Client.
 // These can be used to configure the Client.
 type Opt func(c *Client)
@@ -40,10 +48,11 @@ func WithHTTPClient(cli httpClient) Opt {
 //
 // This allows the client to deal with an increase in
 // request rate.
+//
+// It can be changed later with Client.SetMaxBufferSize.
 func WithMaxBufferSize(size int) Opt {
 	return func(c *Client) {
 		c.cfg.maxBufferSize = size
-		c.msgs = make(chan Message, size)
 	}
 }
 
@@ -51,14 +60,20 @@ func WithMaxBufferSize(size int) Opt {
 func WithRateLimiter(rl rateLimiter) Opt {
 	return func(c *Client) {
 		c.rl = rl
+		c.cfg.maxRps = 0
+		c.cfg.refill = 0
 	}
 }
 
 // WithMaxRpsAndRefill sets the rps for the rate limiter along
 // with the tokens that are to be refilled.
+//
+// It can be changed later with Client.SetRateLimit.
 func WithMaxRpsAndRefill(rps uint64, refill uint64) Opt {
 	return func(c *Client) {
 		c.rl = ratelimiter.New(rps, refill)
+		c.cfg.maxRps = rps
+		c.cfg.refill = refill
 	}
 }
 
@@ -72,6 +87,9 @@ func WithShutDownGraceDuration(d time.Duration) Opt {
 
 // WithLoggingEnabled enables and sets the log level for the Client.
 //
+// Logs are written to stderr using logrus. Use WithLogger
+// to route logs elsewhere.
+//
 // It is turned off by default.
 func WithLoggingEnabled(ll log.Level) Opt {
 	return func(c *Client) {
@@ -79,6 +97,20 @@ func WithLoggingEnabled(ll log.Level) Opt {
 	}
 }
 
+// WithLogger sets the logger the Client writes logs to.
+//
+// Adapters are provided for logrus, log/slog and
+// a logger that discards logs, which is used if l
+// is nil.
+func WithLogger(l Logger) Opt {
+	return func(c *Client) {
+		if l == nil {
+			l = NopLogger()
+		}
+		c.logger = l
+	}
+}
+
 // WithMetrics allows passing in a custom registry
 // and allow metric collection.
 // It is disabled by default.
@@ -86,16 +118,247 @@ func WithLoggingEnabled(ll log.Level) Opt {
 // By default, a registry is created if not set.
 func WithMetrics(r *prometheus.Registry) Opt {
 	return func(c *Client) {
-		c.metrics = newMetrics(true, r)
+		if r == nil {
+			r = prometheus.NewRegistry()
+		}
+		c.cfg.metrics.enabled = true
+		c.cfg.metrics.reg = r
+	}
+}
+
+// WithMetricsNamespace sets the namespace that is
+// prefixed to the names of all metrics.
+//
+// This allows multiple clients to share a registry.
+func WithMetricsNamespace(ns string) Opt {
+	return func(c *Client) {
+		c.cfg.metrics.namespace = ns
+	}
+}
+
+// WithMetricsLabels sets labels that are added to all
+// metrics, such as a client name or endpoint.
+func WithMetricsLabels(labels map[string]string) Opt {
+	return func(c *Client) {
+		c.cfg.metrics.labels = labels
+	}
+}
+
+// WithLatencyBuckets sets the buckets, in seconds, of the
+// request latency, delivery latency and rate limit wait
+// histograms.
+//
+// The Prometheus default buckets are used by default.
+func WithLatencyBuckets(buckets []float64) Opt {
+	return func(c *Client) {
+		c.cfg.metrics.latencyBuckets = buckets
+	}
+}
+
+// WithPayloadSizeBuckets sets the buckets, in bytes,
+// of the payload size histogram.
+func WithPayloadSizeBuckets(buckets []float64) Opt {
+	return func(c *Client) {
+		c.cfg.metrics.sizeBuckets = buckets
 	}
 }
 
 // WithMaxConcurrency sets the max number of workers available
 // to process new messages.
 //
-// It is set to 100 by default.
+// It is set to 100 by default and can be changed later
+// with Client.SetMaxConcurrency.
 func WithMaxConcurrency(cn int) Opt {
 	return func(c *Client) {
 		c.cfg.maxConcurrency = cn
 	}
 }
+
+// WithDeduplication enables suppressing duplicate messages
+// before they are queued.
+//
+// A message is a duplicate if a message with the same key
+// was accepted within the window. At most maxKeys keys are
+// remembered, after which the oldest keys are forgotten.
+//
+// A window that is not positive is set to 5m, and maxKeys
+// that is not positive to 10000.
+//
+// It is disabled by default.
+func WithDeduplication(by DedupKey, window time.Duration, maxKeys int) Opt {
+	return func(c *Client) {
+		if window <= 0 {
+			window = defaultDedupWindow
+		}
+		if maxKeys <= 0 {
+			maxKeys = defaultDedupMaxKeys
+		}
+
+		c.dedup = newDeduper(by, window, maxKeys)
+	}
+}
+
+// WithMaxScheduled sets the max number of messages that
+// can be scheduled for later delivery at once.
+//
+// It is set to 1000 by default.
+func WithMaxScheduled(n int) Opt {
+	return func(c *Client) {
+		c.cfg.maxScheduled = n
+		c.scheduler = newScheduler(n)
+	}
+}
+
+// WithDefaultTTL sets the time to live of messages that
+// do not set one with WithTTL or WithDeadline.
+//
+// Messages are dropped instead of sent if they are still
+// waiting to be sent once it has elapsed.
+//
+// Messages never expire by default.
+func WithDefaultTTL(d time.Duration) Opt {
+	return func(c *Client) {
+		c.cfg.defaultTTL = d
+	}
+}
+
+// WithEarliestDeadlineFirst sends queued messages in order
+// of their deadline rather than the order they were queued in.
+//
+// Messages without a deadline are sent after those with one.
+// Messages with a higher priority set with WithPriority are
+// still sent first.
+func WithEarliestDeadlineFirst() Opt {
+	return func(c *Client) {
+		c.cfg.earliestDeadlineFirst = true
+	}
+}
+
+// WithMaxRetries sets the number of times a message is
+// sent again after a retryable failure, waiting for the
+// backoff duration before the first retry. The backoff
+// doubles with every retry, up to 30s.
+//
+// A negative n disables retries, and a backoff that is not
+// positive is set to 100ms.
+//
+// Retries also go through the rate limiter.
+//
+// Messages are not retried by default.
+func WithMaxRetries(n int, backoff time.Duration) Opt {
+	return func(c *Client) {
+		if n < 0 {
+			n = 0
+		}
+		if backoff <= 0 {
+			backoff = defaultRetryBackoff
+		}
+
+		c.cfg.maxRetries = n
+		c.cfg.retryBackoff = backoff
+	}
+}
+
+// WithTracerProvider enables recording OpenTelemetry spans
+// for enqueuing messages, the time they wait in the queue
+// and for the rate limiter, and for each HTTP attempt.
+//
+// Tracing is disabled by default.
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(c *Client) {
+		c.cfg.tracerProvider = tp
+	}
+}
+
+// WithPropagator sets the propagator used to inject the
+// trace context into outgoing requests.
+//
+// By default, W3C trace context headers are injected.
+func WithPropagator(p propagation.TextMapPropagator) Opt {
+	return func(c *Client) {
+		c.cfg.propagator = p
+	}
+}
+
+// WithMiddleware adds middleware that wraps every attempt
+// at sending a message.
+//
+// Middleware runs in the order given, across calls, with
+// the first middleware being the outermost.
+func WithMiddleware(mw ...Middleware) Opt {
+	return func(c *Client) {
+		c.cfg.middleware = append(c.cfg.middleware, mw...)
+	}
+}
+
+// WithResponseClassifier sets the classifier that decides
+// whether a message was delivered, and if not, whether it
+// can be retried.
+//
+// DefaultClassifier is used by default.
+func WithResponseClassifier(fn ResponseClassifier) Opt {
+	return func(c *Client) {
+		c.cfg.classifier = fn
+	}
+}
+
+// WithResponseBodyLimit sets the max number of bytes of the
+// response body that are read and passed to the classifier.
+//
+// It is set to 4KiB by default.
+func WithResponseBodyLimit(n int64) Opt {
+	return func(c *Client) {
+		c.cfg.responseBodyLimit = n
+	}
+}
+
+// WithResponseDrainLimit sets the max number of bytes of the
+// response body that are read and discarded after the
+// response was classified.
+//
+// Draining the body allows the connection to be reused
+// for the next request. Responses with larger bodies have
+// their connection closed instead. Setting it to zero
+// disables draining.
+//
+// It is set to 64KiB by default.
+func WithResponseDrainLimit(n int64) Opt {
+	return func(c *Client) {
+		c.cfg.responseDrainLimit = n
+	}
+}
+
+// WithMaxIdleConnsPerHost sets the max number of idle
+// connections the default HTTP client keeps open.
+//
+// It is set to the max concurrency by default, so that
+// every worker can reuse a connection. It has no effect
+// if WithHTTPClient is used.
+func WithMaxIdleConnsPerHost(n int) Opt {
+	return func(c *Client) {
+		c.cfg.transport.maxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets the time after which the default
+// HTTP client closes idle connections.
+//
+// It is set to 90s by default. It has no effect if
+// WithHTTPClient is used.
+func WithIdleConnTimeout(d time.Duration) Opt {
+	return func(c *Client) {
+		c.cfg.transport.idleConnTimeout = d
+	}
+}
+
+// WithHTTP2 enables or disables HTTP/2 on the default
+// HTTP client.
+//
+// HTTP/2 is enabled by default and only used if the
+// receiver supports it over TLS. It has no effect if
+// WithHTTPClient is used.
+func WithHTTP2(enabled bool) Opt {
+	return func(c *Client) {
+		c.cfg.transport.http2 = enabled
+	}
+}
@@ -0,0 +1,85 @@
+package notification
+
+import "time"
+
+// Pause stops the Client from sending messages.
+//
+// Messages are still accepted and queued while the Client
+// is paused, and are sent once it is resumed. Workers that
+// are sending a message when the Client is paused finish
+// doing so.
+//
+// Messages that expire while the Client is paused are
+// dropped when it is resumed. Stop discards queued
+// messages whether the Client is paused or not.
+func (c *Client) Pause() {
+	c.pause(time.Time{})
+}
+
+// PauseUntil pauses the Client until the given time,
+// after which it resumes automatically.
+//
+// Calling Pause, PauseUntil or Resume before then
+// cancels the automatic resume.
+func (c *Client) PauseUntil(t time.Time) {
+	c.pause(t)
+}
+
+// Resume continues sending messages after the Client
+// was paused.
+func (c *Client) Resume() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.resume()
+}
+
+// Paused returns if the Client is paused.
+func (c *Client) Paused() bool {
+	return c.msgs.Paused()
+}
+
+func (c *Client) pause(until time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.stopResumeTimer()
+	c.msgs.Pause()
+	c.metrics.setPaused(true)
+
+	if !until.IsZero() {
+		var t *time.Timer
+		t = time.AfterFunc(time.Until(until), func() {
+			c.m.Lock()
+			defer c.m.Unlock()
+
+			// The timer may have fired while being
+			// replaced by a later call to pause.
+			if c.resumeTimer == t {
+				c.resume()
+			}
+		})
+		c.resumeTimer = t
+	}
+
+	c.logger.Info("pausing message sending", "until", until)
+}
+
+// resume must be called with the lock held.
+func (c *Client) resume() {
+	c.stopResumeTimer()
+	c.msgs.Resume()
+	c.metrics.setPaused(false)
+
+	c.logger.Info("resuming message sending")
+}
+
+// stopResumeTimer cancels a pending automatic resume.
+//
+// It must be called with the lock held.
+func (c *Client) stopResumeTimer() {
+	if c.resumeTimer != nil {
+		c.resumeTimer.Stop()
+		c.resumeTimer = nil
+	}
+}
@@ -0,0 +1,91 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SetMaxConcurrency changes the number of workers that
+// process messages.
+//
+// It can be called while the Client is running. When
+// the number of workers is reduced, workers that are
+// sending a message finish doing so before exiting.
+//
+// No queued messages are dropped. The default HTTP client
+// keeps as many idle connections as there are workers,
+// unless WithMaxIdleConnsPerHost is used.
+func (c *Client) SetMaxConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("max concurrency must be at least 1, got %d", n)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.cfg.maxConcurrency = n
+	if c.started {
+		c.scaleWorkers(n)
+	}
+	if c.transport != nil && c.cfg.transport.maxIdleConnsPerHost == 0 {
+		c.transport.growIdleConns(n)
+	}
+	c.metrics.setMaxConcurrency(n)
+
+	c.logger.Info("changed max concurrency", "max_concurrency", n)
+
+	return nil
+}
+
+// SetRateLimit changes the rps of the rate limiter along
+// with the tokens that are to be refilled.
+//
+// It can be called while the Client is running.
+//
+// It returns an error if the rate limiter set with
+// WithRateLimiter does not support changing its rate.
+func (c *Client) SetRateLimit(rps uint64, refill uint64) error {
+	if rps == 0 {
+		return errors.New("rps must be at least 1")
+	}
+
+	rl, ok := c.rl.(adjustableRateLimiter)
+	if !ok {
+		return errors.New("rate limiter does not support changing its rate")
+	}
+	rl.SetRate(rps, refill)
+
+	c.m.Lock()
+	c.cfg.maxRps = rps
+	c.cfg.refill = refill
+	c.m.Unlock()
+	c.metrics.setMaxRps(rps)
+
+	c.logger.Info("changed rate limit", "max_rps", rps, "refill", refill)
+
+	return nil
+}
+
+// SetMaxBufferSize changes the max number of messages
+// that the Client can buffer.
+//
+// It can be called while the Client is running. If the
+// buffer holds more messages than the new size, none of
+// them are dropped; new messages are refused until the
+// buffer has drained below the new size.
+func (c *Client) SetMaxBufferSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("max buffer size must be at least 1, got %d", size)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.cfg.maxBufferSize = size
+	c.msgs.SetCap(size)
+	c.metrics.setClientMaxBufferSize(size)
+
+	c.logger.Info("changed max buffer size", "max_buffer_size", size)
+
+	return nil
+}
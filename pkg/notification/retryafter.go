@@ -0,0 +1,159 @@
+package notification
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	minEnqueueRetryDuration = 10 * time.Millisecond
+	maxEnqueueRetryDuration = 30 * time.Second
+
+	// enqueueRetryDrainRatio is the share of the queue that
+	// is expected to drain before enqueueing is retried.
+	//
+	// Waiting for more than a single slot to free up keeps
+	// producers from retrying for every message sent.
+	enqueueRetryDrainRatio = 0.1
+
+	// rateMeterInterval is the interval over which the
+	// drain rate is sampled.
+	rateMeterInterval = time.Second
+
+	// rateMeterAlpha is the weight given to the latest
+	// sample of the drain rate.
+	rateMeterAlpha = 0.3
+)
+
+// rateMeter measures the rate of events per second as an
+// exponentially weighted moving average.
+//
+// It is safe for concurrent use.
+type rateMeter struct {
+	// count is the number of events in the current sample.
+	count int
+
+	// start is the time at which the current sample started.
+	start time.Time
+
+	// rate is the average rate of previous samples.
+	rate float64
+
+	// sampled is set once the first sample was taken.
+	sampled bool
+
+	m sync.Mutex
+}
+
+func newRateMeter(now time.Time) *rateMeter {
+	return &rateMeter{start: now}
+}
+
+// mark records an event.
+func (r *rateMeter) mark(now time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.tick(now)
+	r.count++
+}
+
+// perSecond returns the average rate of events.
+//
+// It returns zero until a full interval has passed.
+func (r *rateMeter) perSecond(now time.Time) float64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.tick(now)
+
+	return r.rate
+}
+
+// tick folds the current sample into the average
+// once the interval has passed.
+//
+// It must be called with the lock held.
+func (r *rateMeter) tick(now time.Time) {
+	elapsed := now.Sub(r.start)
+	if elapsed < rateMeterInterval {
+		return
+	}
+
+	sample := float64(r.count) / elapsed.Seconds()
+	if r.sampled {
+		r.rate = rateMeterAlpha*sample + (1-rateMeterAlpha)*r.rate
+	} else {
+		r.rate = sample
+		r.sampled = true
+	}
+
+	r.count = 0
+	r.start = now
+}
+
+// enqueueRetryAfter estimates how long it takes for
+// the full message queue to have room again.
+//
+// The estimate is based on the time it takes to drain
+// a share of the queue, at the rate messages were taken
+// off the queue recently but no faster than the rate
+// limit allows.
+//
+// It falls back to the default when the Client is
+// paused or when the rate is not known.
+func (c *Client) enqueueRetryAfter() time.Duration {
+	if c.Paused() {
+		return defaultEnqueueRetryDuration
+	}
+
+	c.m.Lock()
+	limit := rateLimit(c.cfg.maxRps, c.cfg.refill)
+	c.m.Unlock()
+
+	rate := c.drained.perSecond(time.Now())
+	if rate == 0 || limit > 0 && limit < rate {
+		rate = limit
+	}
+	if rate == 0 {
+		return defaultEnqueueRetryDuration
+	}
+
+	n := math.Max(1, math.Ceil(float64(c.msgs.Len())*enqueueRetryDrainRatio))
+
+	return clampRetryAfter(time.Duration(n / rate * float64(time.Second)))
+}
+
+// rateLimit returns the sustained rate allowed by a rate
+// limiter that is refilled with refill tokens rps times
+// per second, holding up to rps tokens.
+func rateLimit(rps, refill uint64) float64 {
+	if refill > rps {
+		refill = rps
+	}
+
+	return float64(rps) * float64(refill)
+}
+
+// scheduleRetryAfter returns the time until the next
+// scheduled message is due, which frees up its slot.
+func (c *Client) scheduleRetryAfter() time.Duration {
+	next, ok := c.scheduler.nextDue()
+	if !ok {
+		return defaultEnqueueRetryDuration
+	}
+
+	return clampRetryAfter(time.Until(next))
+}
+
+func clampRetryAfter(d time.Duration) time.Duration {
+	switch {
+	case d < minEnqueueRetryDuration:
+		return minEnqueueRetryDuration
+	case d > maxEnqueueRetryDuration:
+		return maxEnqueueRetryDuration
+	default:
+		return d
+	}
+}
@@ -0,0 +1,184 @@
+package notification
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// schedule is a min heap of envelopes ordered by the
+// time at which they are due to be sent.
+//
+// It implements heap.Interface.
+type schedule []envelope
+
+func (s schedule) Len() int           { return len(s) }
+func (s schedule) Less(i, j int) bool { return s[i].sendAt.Before(s[j].sendAt) }
+func (s schedule) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *schedule) Push(x interface{}) {
+	*s = append(*s, x.(envelope))
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	n := len(old)
+	e := old[n-1]
+	*s = old[:n-1]
+
+	return e
+}
+
+// scheduler holds messages that are to be sent at a later
+// time and releases them once they are due.
+//
+// It is safe for concurrent use.
+type scheduler struct {
+	// items holds the scheduled messages.
+	items schedule
+
+	// max is the max number of messages that can
+	// be scheduled at once.
+	max int
+
+	// wake is signalled when a new message is scheduled
+	// so that the run loop can re-evaluate its timer.
+	wake chan struct{}
+
+	m sync.Mutex
+}
+
+func newScheduler(max int) *scheduler {
+	return &scheduler{
+		max:  max,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// add schedules the envelope to be released at its send time.
+//
+// It returns false if the scheduler is full.
+func (s *scheduler) add(e envelope) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.items) >= s.max {
+		return false
+	}
+	heap.Push(&s.items, e)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// len returns the number of scheduled messages.
+func (s *scheduler) len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.items)
+}
+
+// nextDue returns the time at which the earliest
+// envelope is due.
+//
+// It returns false if there are no scheduled envelopes.
+func (s *scheduler) nextDue() (time.Time, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.items) == 0 {
+		return time.Time{}, false
+	}
+
+	return s.items[0].sendAt, true
+}
+
+// drain removes and returns all scheduled envelopes
+// in the order they are due.
+func (s *scheduler) drain() []envelope {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	es := make([]envelope, 0, len(s.items))
+	for len(s.items) > 0 {
+		es = append(es, heap.Pop(&s.items).(envelope))
+	}
+
+	return es
+}
+
+// next pops the earliest envelope if it is due.
+//
+// If it is not, it returns the duration until it is.
+// If there are no scheduled envelopes, the duration
+// is negative.
+func (s *scheduler) next(now time.Time) (envelope, time.Duration, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.items) == 0 {
+		return envelope{}, -1, false
+	}
+
+	if d := s.items[0].sendAt.Sub(now); d > 0 {
+		return envelope{}, d, false
+	}
+
+	return heap.Pop(&s.items).(envelope), 0, true
+}
+
+// run releases envelopes as they become due until
+// done is closed.
+//
+// release is called with every due envelope. If it
+// returns false, the envelope is scheduled again and
+// released after the retry duration.
+func (s *scheduler) run(done <-chan struct{}, release func(e envelope) bool) {
+	for {
+		e, wait, ok := s.next(time.Now())
+		if ok {
+			if release(e) {
+				continue
+			}
+
+			// Put the envelope back, bypassing the size
+			// check since it already had a slot.
+			s.m.Lock()
+			heap.Push(&s.items, e)
+			s.m.Unlock()
+
+			wait = defaultScheduleRetryDuration
+		}
+
+		// A nil timer channel blocks forever, which is
+		// what we want when nothing is scheduled.
+		var (
+			t     *time.Timer
+			timer <-chan time.Time
+		)
+		if wait >= 0 {
+			t = time.NewTimer(wait)
+			timer = t.C
+		}
+
+		select {
+		case <-timer:
+		case <-s.wake:
+		case <-done:
+			stopTimer(t)
+			return
+		}
+		stopTimer(t)
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
@@ -0,0 +1,175 @@
+package notification
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time snapshot of the state of a Client.
+type Stats struct {
+	// QueueLength is the number of messages waiting
+	// to be sent.
+	QueueLength int
+
+	// QueueCapacity is the max number of messages
+	// that can be queued.
+	QueueCapacity int
+
+	// Scheduled is the number of messages held
+	// for delivery at a later time.
+	Scheduled int
+
+	// InFlight is the number of requests currently
+	// being made.
+	InFlight int
+
+	// Pending is the number of accepted messages whose
+	// delivery has not finished yet, including queued,
+	// scheduled and in flight messages.
+	Pending int
+
+	// Workers is the number of running workers.
+	Workers int
+
+	// Paused reports if the Client is paused.
+	Paused bool
+
+	// Delivered is the total number of messages
+	// sent successfully.
+	Delivered uint64
+
+	// Failed is the total number of messages that
+	// could not be sent.
+	Failed uint64
+
+	// Dropped is the total number of queued messages
+	// that were never sent, either because they expired,
+	// because the rate limit was not lifted in time or
+	// because the Client was stopped.
+	Dropped uint64
+
+	// Retried is the total number of times a message
+	// was sent again after a retryable failure.
+	Retried uint64
+
+	// RateLimiterTokens is the number of tokens the rate
+	// limiter currently has.
+	//
+	// It is -1 if the rate limiter does not report them.
+	RateLimiterTokens int64
+
+	// LastErrorTime is the time at which a message
+	// last failed or was dropped.
+	//
+	// It is the zero time if none have.
+	LastErrorTime time.Time
+}
+
+// Stats returns a snapshot of the state of the Client.
+//
+// It is cheap to call and does not depend on
+// metrics being enabled.
+func (c *Client) Stats() Stats {
+	c.m.Lock()
+	workers := len(c.workers)
+	c.m.Unlock()
+
+	tokens := int64(-1)
+	if rl, ok := c.rl.(interface{ Tokens() uint64 }); ok {
+		tokens = int64(rl.Tokens())
+	}
+
+	var lastErr time.Time
+	if n := atomic.LoadInt64(&c.stats.lastErr); n != 0 {
+		lastErr = time.Unix(0, n)
+	}
+
+	return Stats{
+		QueueLength:       c.msgs.Len(),
+		QueueCapacity:     c.msgs.Cap(),
+		Scheduled:         c.scheduler.len(),
+		InFlight:          c.stats.inFlightRequests(),
+		Pending:           c.stats.pendingMessages(),
+		Workers:           workers,
+		Paused:            c.msgs.Paused(),
+		Delivered:         atomic.LoadUint64(&c.stats.delivered),
+		Failed:            atomic.LoadUint64(&c.stats.failed),
+		Dropped:           atomic.LoadUint64(&c.stats.droppedTotal),
+		Retried:           atomic.LoadUint64(&c.stats.retriedTotal),
+		RateLimiterTokens: tokens,
+		LastErrorTime:     lastErr,
+	}
+}
+
+// counters holds the delivery counters of a Client.
+//
+// Fields are accessed atomically. It must be allocated
+// on its own so that they are 64-bit aligned.
+type counters struct {
+	delivered    uint64
+	failed       uint64
+	droppedTotal uint64
+	retriedTotal uint64
+
+	inFlight int64
+	pending  int64
+
+	// lastErr is the time of the last failure
+	// in unix nanoseconds.
+	lastErr int64
+}
+
+// inFlightRequests returns the number of
+// requests being made.
+func (s *counters) inFlightRequests() int {
+	return int(atomic.LoadInt64(&s.inFlight))
+}
+
+// pendingMessages returns the number of messages
+// whose delivery has not finished.
+func (s *counters) pendingMessages() int {
+	return int(atomic.LoadInt64(&s.pending))
+}
+
+// accepted records a message that is to be delivered.
+func (s *counters) accepted() {
+	atomic.AddInt64(&s.pending, 1)
+}
+
+// settled records a message whose delivery finished,
+// whatever the outcome.
+func (s *counters) settled() {
+	atomic.AddInt64(&s.pending, -1)
+}
+
+// sending records the start of a request.
+func (s *counters) sending() {
+	atomic.AddInt64(&s.inFlight, 1)
+}
+
+// sent records the outcome of a request.
+func (s *counters) sent(err error, now time.Time) {
+	atomic.AddInt64(&s.inFlight, -1)
+
+	if err == nil {
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	}
+	atomic.StoreInt64(&s.lastErr, now.UnixNano())
+}
+
+// fail records a message that could not be sent.
+func (s *counters) fail() {
+	atomic.AddUint64(&s.failed, 1)
+}
+
+// dropped records a message that was never sent.
+func (s *counters) dropped(now time.Time) {
+	atomic.AddUint64(&s.droppedTotal, 1)
+	atomic.StoreInt64(&s.lastErr, now.UnixNano())
+}
+
+// retried records a message that is sent again.
+func (s *counters) retried() {
+	atomic.AddUint64(&s.retriedTotal, 1)
+}
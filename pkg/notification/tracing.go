@@ -0,0 +1,109 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/vivangkumar/notify/pkg/notification"
+
+// Names of the spans recorded by the Client.
+const (
+	spanEnqueue       = "notification.enqueue"
+	spanQueueWait     = "notification.queue_wait"
+	spanRateLimitWait = "notification.rate_limit_wait"
+	spanSend          = "notification.send"
+)
+
+// tracing records spans for the lifecycle of messages
+// and propagates the trace context to the upstream service.
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newTracing(tp trace.TracerProvider, p propagation.TextMapPropagator) tracing {
+	return tracing{
+		tracer:     tp.Tracer(tracerName),
+		propagator: p,
+	}
+}
+
+// enqueue starts the span that covers queuing the envelope.
+//
+// The span is a child of the span in the context the
+// message was submitted with, if any. The envelope is
+// updated to carry the span so that later spans of the
+// message are its children.
+func (t tracing) enqueue(e *envelope) trace.Span {
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, span := t.tracer.Start(ctx, spanEnqueue, trace.WithAttributes(
+		attribute.String("message.id", e.id),
+		attribute.Int("message.size", len(e.msg)),
+	))
+	e.spanCtx = trace.SpanContextFromContext(ctx)
+
+	return span
+}
+
+// queueWait records the span for the time the envelope
+// spent in the message queue.
+func (t tracing) queueWait(e envelope) {
+	_, span := t.tracer.Start(e.traceCtx(), spanQueueWait, trace.WithTimestamp(e.queuedAt))
+	span.End()
+}
+
+// rateLimitWait starts the span that covers waiting
+// for the rate limiter.
+func (t tracing) rateLimitWait(e envelope, attempt int) trace.Span {
+	_, span := t.tracer.Start(e.traceCtx(), spanRateLimitWait, trace.WithAttributes(
+		attribute.Int("attempt", attempt),
+	))
+
+	return span
+}
+
+// send starts the span that covers a single HTTP attempt
+// and injects its trace context into the request headers.
+func (t tracing) send(e envelope, attempt int, req *http.Request) trace.Span {
+	ctx, span := t.tracer.Start(e.traceCtx(), spanSend,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("message.id", e.id),
+			attribute.Int("attempt", attempt),
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+		),
+	)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	return span
+}
+
+// endSpan ends the span, recording err if it is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceCtx returns a context carrying the span context of
+// the envelope.
+//
+// It is detached from the context the message was submitted
+// with, so that cancelling that context does not affect
+// delivery.
+func (e envelope) traceCtx() context.Context {
+	return trace.ContextWithSpanContext(context.Background(), e.spanCtx)
+}
@@ -0,0 +1,130 @@
+package notification
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transportConfig configures the transport of the
+// default HTTP client.
+//
+// It has no effect when an HTTP client is set
+// with WithHTTPClient.
+type transportConfig struct {
+	// maxIdleConnsPerHost is the max number of idle
+	// connections kept open to the receiver.
+	//
+	// It follows the max concurrency if zero.
+	maxIdleConnsPerHost int
+
+	// idleConnTimeout is the time after which idle
+	// connections are closed.
+	idleConnTimeout time.Duration
+
+	// http2 enables HTTP/2 for TLS connections.
+	http2 bool
+}
+
+// defaultTransport is the transport of the HTTP client
+// used when none is set with WithHTTPClient.
+//
+// It is based on http.DefaultTransport, tuned so that
+// connections are kept alive for every worker. The
+// settings of an http.Transport cannot be changed while
+// it is used, so it is replaced to keep more idle
+// connections once there are more workers.
+type defaultTransport struct {
+	// t holds the current *http.Transport.
+	t atomic.Value
+
+	// m serializes replacing the transport.
+	m sync.Mutex
+}
+
+func newDefaultTransport(cfg transportConfig, maxConcurrency int) *defaultTransport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 0
+	t.MaxIdleConnsPerHost = cfg.maxIdleConnsPerHost
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = maxConcurrency
+	}
+	t.IdleConnTimeout = cfg.idleConnTimeout
+	t.ForceAttemptHTTP2 = cfg.http2
+	if !cfg.http2 {
+		// A non-nil empty map disables HTTP/2.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	d := &defaultTransport{}
+	d.t.Store(t)
+
+	return d
+}
+
+// RoundTrip implements http.RoundTripper.
+func (d *defaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return d.current().RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections
+// of the current transport.
+func (d *defaultTransport) CloseIdleConnections() {
+	d.current().CloseIdleConnections()
+}
+
+func (d *defaultTransport) current() *http.Transport {
+	return d.t.Load().(*http.Transport)
+}
+
+// growIdleConns raises the max number of idle
+// connections to n if it is lower.
+//
+// The old transport closes its connections once they
+// are idle, and new requests open connections with the
+// new one.
+func (d *defaultTransport) growIdleConns(n int) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	old := d.current()
+	if n <= old.MaxIdleConnsPerHost {
+		return
+	}
+
+	t := old.Clone()
+	t.MaxIdleConnsPerHost = n
+	d.t.Store(t)
+	old.CloseIdleConnections()
+}
+
+// traceConnections records whether the request was sent
+// over a new or a reused connection.
+func (c *Client) traceConnections(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.metrics.incrConnections(info.Reused)
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// drainAndClose reads up to limit bytes of what remains
+// of body before closing it.
+//
+// The transport only returns a connection to the pool once
+// the body was read to the end, so draining it allows the
+// connection to be reused. Bodies larger than the limit are
+// not worth reading and their connection is closed instead.
+func drainAndClose(body io.ReadCloser, limit int64) {
+	if limit > 0 {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(body, limit))
+	}
+	_ = body.Close()
+}